@@ -1,43 +1,4 @@
-{{- if .EnableWebSocket }}
-map $http_upgrade $connection_upgrade {
-    default upgrade;
-    '' close;
-}
-{{- end }}
-
-upstream {{ .ServiceName }} {
-{{- range .Upstream }}
-    server {{ .IP }}:{{ .Port.Port }};
-{{- end }}
-}
-
-{{- if .EnableSSL }}
-{{- if .ForceHTTPS }}
-# HTTP 到 HTTPS 重定向
-server {
-    listen          80;
-    listen          [::]:80;
-
-    server_name     {{ .Domain }};
-    rewrite ^(.*)$  https://$host$1 permanent;
-}
-{{- end }}
-
-# HTTPS 服务器配置
-server {
-    listen  443       ssl;
-    listen  [::]:443  ssl;
-    http2   on;
-
-    server_name {{ .Domain }};
-
-    {{- if .SSLCertificate }}
-    ssl_certificate     {{ .SSLCertificate }};
-    {{- end }}
-    {{- if .SSLCertificateKey }}
-    ssl_certificate_key {{ .SSLCertificateKey }};
-    {{- end }}
-
+{{- define "location" }}
     location {{ .Path }} {
         {{- if .EnableWebSocket }}
         proxy_http_version 1.1;
@@ -45,11 +6,18 @@ server {
         proxy_set_header Connection $connection_upgrade;
         {{- end }}
 
+        {{- if and .Keepalive (not .EnableWebSocket) }}
+        {{- if not .ProxyHTTPVersion }}
+        proxy_http_version 1.1;
+        {{- end }}
+        proxy_set_header Connection "";
+        {{- end }}
+
         {{- if .ClientMaxBodySize }}
         client_max_body_size {{ .ClientMaxBodySize }};
         {{- end }}
 
-        proxy_pass http://{{ .ServiceName }}/;
+        proxy_pass http://{{ .UpstreamName }}/;
 
         # 增加代理缓冲区设置
         proxy_buffering on;
@@ -71,45 +39,108 @@ server {
         proxy_redirect {{ .ProxyRedirect }};
         {{- end }}
     }
-}
-{{- else }}
-# HTTP 服务器配置
-server {
-    listen 80;
-    server_name {{ .Domain }};
+{{- end }}
 
+{{- define "maintenance" }}
+    # 服务 {{ .ServiceName }} 没有可用的上游服务器
     location {{ .Path }} {
-        {{- if .EnableWebSocket }}
-        proxy_http_version 1.1;
-        proxy_set_header Upgrade $http_upgrade;
-        proxy_set_header Connection $connection_upgrade;
+        {{- if .MaintenanceRedirect }}
+        return 302 {{ .MaintenanceRedirect }};
+        {{- else if .MaintenanceFile }}
+        error_page 503 @{{ .UpstreamName }}_maintenance;
+        return 503;
+        {{- else }}
+        default_type text/plain;
+        return 503 "Service Temporarily Unavailable\n";
         {{- end }}
+    }
+    {{- if and .MaintenanceFile (not .MaintenanceRedirect) }}
 
-        {{- if .ClientMaxBodySize }}
-        client_max_body_size {{ .ClientMaxBodySize }};
-        {{- end }}
+    location @{{ .UpstreamName }}_maintenance {
+        root {{ .MaintenanceRoot }};
+        try_files /{{ .MaintenanceFile }} =503;
+    }
+    {{- end }}
+{{- end }}
+
+{{- if .EnableWebSocket }}
+map $http_upgrade $connection_upgrade {
+    default upgrade;
+    '' close;
+}
+{{- end }}
 
-        proxy_pass http://{{ .ServiceName }}/;
+{{- range .Locations }}
+{{- if .Upstream }}
 
-        # 增加代理缓冲区设置
-        proxy_buffering on;
-        proxy_buffer_size 16k;
-        proxy_buffers 8 16k;
-        proxy_busy_buffers_size 32k;
-        proxy_max_temp_file_size 1024m;
-        proxy_temp_file_write_size 64k;
+upstream {{ .UpstreamName }} {
+    {{- if .Balance }}
+    {{ .Balance }};
+    {{- end }}
+{{- range .Upstream }}
+    server {{ .Address }}{{ .Params }};
+{{- end }}
+    {{- if .Keepalive }}
+    keepalive {{ .Keepalive }};
+    {{- end }}
+}
+{{- end }}
+{{- end }}
 
-        {{- if .ProxyHTTPVersion }}
-        proxy_http_version {{ .ProxyHTTPVersion }};
-        {{- end }}
+{{- if .EnableSSL }}
+{{- if .ForceHTTPS }}
+# HTTP 到 HTTPS 重定向
+server {
+    listen          80;
+    listen          [::]:80;
 
-        {{- range .ProxyHeaders }}
-        proxy_set_header {{ . }};
-        {{- end }}
+    server_name     {{ .ServerName }};
+    rewrite ^(.*)$  https://$host$1 permanent;
+}
+{{- end }}
 
-        {{- if .ProxyRedirect }}
-        proxy_redirect {{ .ProxyRedirect }};
-        {{- end }}
-    }
+# HTTPS 服务器配置
+server {
+    listen  443       ssl;
+    listen  [::]:443  ssl;
+    http2   on;
+
+    server_name {{ .ServerName }};
+
+    {{- if .SSLCertificate }}
+    ssl_certificate     {{ .SSLCertificate }};
+    {{- end }}
+    {{- if .SSLCertificateKey }}
+    ssl_certificate_key {{ .SSLCertificateKey }};
+    {{- end }}
+    {{- if .HSTS }}
+    add_header Strict-Transport-Security "max-age=31536000" always;
+    {{- end }}
+    {{- if .Resolver }}
+    resolver {{ .Resolver }};
+    {{- end }}
+{{- range .Locations }}
+{{- if .Maintenance }}
+{{ template "maintenance" . }}
+{{- else }}
+{{ template "location" . }}
+{{- end }}
+{{- end }}
+}
+{{- else }}
+# HTTP 服务器配置
+server {
+    listen 80;
+    server_name {{ .ServerName }};
+    {{- if .Resolver }}
+    resolver {{ .Resolver }};
+    {{- end }}
+{{- range .Locations }}
+{{- if .Maintenance }}
+{{ template "maintenance" . }}
+{{- else }}
+{{ template "location" . }}
+{{- end }}
+{{- end }}
 }
 {{- end }}
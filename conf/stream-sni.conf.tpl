@@ -19,8 +19,11 @@ upstream {{ $upstream }} {
 {{- if .DefaultRoute }}
 # 默认后端
 upstream {{ .DefaultRoute }} {
+    {{- if .Balance }}
+    {{ .Balance }};
+    {{- end }}
 {{- range .Upstream }}
-    server {{ .IP }}:{{ .Port.Port }};
+    server {{ .Address }}{{ .Params }};
 {{- end }}
 }
 {{- end }}
@@ -29,20 +32,29 @@ upstream {{ .DefaultRoute }} {
 server {
     listen {{ .ListenPort }};
     ssl_preread on;
+    {{- if .Resolver }}
+    resolver {{ .Resolver }};
+    {{- end }}
     proxy_pass $backend_pool;
     proxy_timeout 3s;
     proxy_connect_timeout 1s;
 }
 {{- else }}
 # 传统 Stream 配置
-upstream {{ .ServiceName }} {
+upstream {{ .UpstreamName }} {
+    {{- if .Balance }}
+    {{ .Balance }};
+    {{- end }}
 {{- range .Upstream }}
-    server {{ .IP }}:{{ .Port.Port }};
+    server {{ .Address }}{{ .Params }};
 {{- end }}
 }
 
 server {
     listen {{ .ListenPort }};
-    proxy_pass {{ .ServiceName }};
+    {{- if .Resolver }}
+    resolver {{ .Resolver }};
+    {{- end }}
+    proxy_pass {{ .UpstreamName }};
 }
 {{- end }}
@@ -1,10 +1,32 @@
-upstream {{ .ServiceName }} {
+upstream {{ .UpstreamName }} {
+    {{- if .Balance }}
+    {{ .Balance }};
+    {{- end }}
 {{- range .Upstream }}
-    server {{ .IP }}:{{ .Port.Port }};
+    server {{ .Address }}{{ .Params }};
 {{- end }}
 }
+{{- if .ListenTCP }}
 
 server {
     listen {{ .ListenPort }};
-    proxy_pass {{ .ServiceName }};
+    {{- if .Resolver }}
+    resolver {{ .Resolver }};
+    {{- end }}
+    proxy_pass {{ .UpstreamName }};
 }
+{{- end }}
+{{- if .ListenUDP }}
+
+server {
+    listen {{ .ListenPort }} udp;
+    {{- if .Resolver }}
+    resolver {{ .Resolver }};
+    {{- end }}
+    proxy_pass {{ .UpstreamName }};
+    {{- if .ProxyResponses }}
+    proxy_responses {{ .ProxyResponses }};
+    {{- end }}
+    proxy_timeout {{ .ProxyTimeout }};
+}
+{{- end }}
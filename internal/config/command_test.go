@@ -0,0 +1,39 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    []string
+	}{
+		{"空字符串", "", nil},
+		{"只有空白", " \t\n ", nil},
+		{"按空格拆分", "nginx -s reload", []string{"nginx", "-s", "reload"}},
+		{"连续的空白", "  nginx \t -s\n reload  ", []string{"nginx", "-s", "reload"}},
+		{"双引号", `sh -c "nginx -t && nginx -s reload"`, []string{"sh", "-c", "nginx -t && nginx -s reload"}},
+		{"单引号", `sh -c 'nginx -t && nginx -s reload'`, []string{"sh", "-c", "nginx -t && nginx -s reload"}},
+		{"单引号内的反斜杠不转义", `echo 'a\b'`, []string{"echo", `a\b`}},
+		{"双引号内的单引号", `echo "it's"`, []string{"echo", "it's"}},
+		{"单引号内的双引号", `echo '"a"'`, []string{"echo", `"a"`}},
+		{"双引号内转义双引号", `echo "a\"b"`, []string{"echo", `a"b`}},
+		{"转义空格", `cat a\ b`, []string{"cat", "a b"}},
+		{"引号与其他字符相连", `--name="my nginx"`, []string{"--name=my nginx"}},
+		{"空引号为空参数", `echo ""`, []string{"echo", ""}},
+		{"只有空引号", `""`, []string{""}},
+		{"未闭合的引号包含剩余部分", `echo 'a b`, []string{"echo", "a b"}},
+		{"末尾的反斜杠被忽略", `a\`, []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SplitCommand(tt.command); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitCommand(%q) = %q, 期望 %q", tt.command, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,12 +3,55 @@ package config
 import (
 	"fmt"
 	"os"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// docker compose 为容器设置的标签
+const (
+	ComposeProjectLabel = "com.docker.compose.project"
+	ComposeServiceLabel = "com.docker.compose.service"
+)
+
+// IP协议族
+const (
+	IPFamilyIPv4 = "ipv4"
+	IPFamilyIPv6 = "ipv6"
+	IPFamilyDual = "dual"
+)
+
+// Stream服务的协议
+const (
+	ProtocolTCP  = "tcp"
+	ProtocolUDP  = "udp"
+	ProtocolBoth = "both"
+)
+
+// 上游服务器地址模式
+const (
+	// 使用容器在所选网络中的IP和容器端口
+	AddressModeIP = "ip"
+	// 使用容器名称和容器端口，由nginx通过Docker内置DNS解析，要求nginx容器连接到同一网络
+	AddressModeDNS = "dns"
+	// 使用宿主机IP和发布到宿主机的端口
+	AddressModeHostPort = "host_port"
+)
+
+// HTTP服务没有上游服务器时的处理方式
+const (
+	// 从域名的配置中移除该服务，域名下没有其他服务时删除配置文件
+	OnEmptyDelete = "delete"
+	// 返回503维护页面或重定向
+	OnEmptyMaintenance = "maintenance"
+	// 保留最后的上游服务器
+	OnEmptyKeep = "keep"
+)
+
 // Config 主配置结构
 type Config struct {
 	Global   GlobalConfig    `yaml:"global"`
@@ -28,30 +71,153 @@ type GlobalConfig struct {
 	DefaultProxy          ProxyConfig `yaml:"default_proxy"`
 	// 宿主机IP
 	HostIP string `yaml:"host_ip"`
+	// 宿主机IPv6地址，ip_family 为 ipv6 或 dual 的服务在host和bridge网络下使用
+	HostIPv6 string `yaml:"host_ipv6,omitempty"`
 	// ssl公钥路径
 	SSLCertPath string `yaml:"ssl_certificate,omitempty"`
 	// ssl私钥路径
 	SSLKeyPath string `yaml:"ssl_certificate_key,omitempty"`
 	// 强制走https
 	ForceHTTPS bool `yaml:"force_https,omitempty"`
+	// https响应中返回 Strict-Transport-Security 响应头
+	HSTS bool `yaml:"hsts,omitempty"`
+	// nginx配置测试命令，如 "docker exec nginx-ui nginx -t"，为空时不测试
+	NginxTestCmd string `yaml:"nginx_test_cmd,omitempty"`
+	// 合并重载的时间窗口，最后一次变更后等待该时间再重载，默认500ms
+	ReloadDebounce time.Duration `yaml:"reload_debounce,omitempty"`
+	// 从第一次变更起最多等待的时间，默认5s
+	ReloadMaxDelay time.Duration `yaml:"reload_max_delay,omitempty"`
+	// nginx重载方式，未配置时使用 nginx_reload_cmd
+	Reload ReloadConfig `yaml:"reload,omitempty"`
+	// 定期将nginx配置与运行中的容器同步的间隔，默认60s
+	ReconcileInterval time.Duration `yaml:"reconcile_interval,omitempty"`
+	// 服务未指定 network 时默认使用的网络
+	DefaultNetwork StringList `yaml:"default_network,omitempty"`
+	// 默认的上游服务器地址模式: ip、dns、host_port，为空时按网络自动选择
+	UpstreamAddressMode string `yaml:"upstream_address_mode,omitempty"`
+	// dns 地址模式下写入nginx配置的 resolver，默认为Docker内置DNS 127.0.0.11
+	Resolver string `yaml:"resolver,omitempty"`
+	// nginx所在的容器名称，用于检查 dns 地址模式下nginx是否与上游容器在同一网络，默认使用 reload.container
+	NginxContainer string `yaml:"nginx_container,omitempty"`
+	// 容器停止时先将其标记为 down 并等待该时间再移除，为0时立即移除
+	DrainPeriod time.Duration `yaml:"drain_period,omitempty"`
+	// HTTP服务没有上游服务器时的默认处理方式: delete（默认）、maintenance、keep
+	OnEmpty string `yaml:"on_empty,omitempty"`
+	// on_empty 为 maintenance 时默认的维护页面
+	Maintenance MaintenanceConfig `yaml:"maintenance,omitempty"`
+}
+
+// MaintenanceConfig 维护页面配置，都为空时返回纯文本的503响应
+type MaintenanceConfig struct {
+	// 503响应使用的静态页面，为nginx可以读取的绝对路径
+	Page string `yaml:"page,omitempty"`
+	// 重定向到的URL，使用302状态码
+	Redirect string `yaml:"redirect,omitempty"`
+}
+
+// ReloadConfig nginx重载方式配置
+type ReloadConfig struct {
+	// 重载方式: command、docker_exec、docker_signal、pidfile
+	Mode string `yaml:"mode,omitempty"`
+	// docker_exec 和 docker_signal 方式的nginx容器名称
+	Container string `yaml:"container,omitempty"`
+	// docker_signal 和 pidfile 方式发送的信号，默认 SIGHUP
+	Signal string `yaml:"signal,omitempty"`
+	// pidfile 方式的nginx pid文件路径
+	PIDFile string `yaml:"pid_file,omitempty"`
+	// command 方式在本机执行的重载命令，docker_exec 方式在容器内执行的重载命令（默认 nginx -s reload）
+	Command []string `yaml:"command,omitempty"`
+	// 配置测试命令，docker_exec 方式在容器内执行，其他方式在本机执行
+	TestCommand []string `yaml:"test_command,omitempty"`
 }
 
 // ServiceConfig 服务配置
 type ServiceConfig struct {
-	Name            string                 `yaml:"name"`
-	Type            string                 `yaml:"type"` // http 或 stream
-	ContainerName   string                 `yaml:"container_name"`
-	Domain          string                 `yaml:"domain,omitempty"`
-	Path            string                 `yaml:"path,omitempty"`
-	Port            int                    `yaml:"port,omitempty"`
-	ListenPort      int                    `yaml:"listen_port,omitempty"`
-	ContainerPort   int                    `yaml:"container_port,omitempty"`
-	UpstreamName    string                 `yaml:"upstream_name"`
-	ProxyConfig     *ProxyConfig           `yaml:"proxy_config,omitempty"`
+	Name          string       `yaml:"name"`
+	Type          string       `yaml:"type"` // http 或 stream
+	ContainerName string       `yaml:"container_name"`
+	Domain        string       `yaml:"domain,omitempty"`
+	Domains       []string     `yaml:"domains,omitempty"` // 域名别名，与 domain 一起生成在 server_name 中
+	Path          string       `yaml:"path,omitempty"`
+	Port          int          `yaml:"port,omitempty"`
+	ListenPort    int          `yaml:"listen_port,omitempty"`
+	ContainerPort int          `yaml:"container_port,omitempty"`
+	UpstreamName  string       `yaml:"upstream_name"`
+	ProxyConfig   *ProxyConfig `yaml:"proxy_config,omitempty"`
 	// SNI 路由相关字段
-	EnableSNI       bool                   `yaml:"enable_sni,omitempty"`
-	DomainRoutes    map[string]string      `yaml:"domain_routes,omitempty"`
-	StaticUpstreams map[string][]string    `yaml:"static_upstreams,omitempty"` // 静态upstream配置
+	EnableSNI       bool                `yaml:"enable_sni,omitempty"`
+	DomainRoutes    map[string]string   `yaml:"domain_routes,omitempty"`
+	StaticUpstreams map[string][]string `yaml:"static_upstreams,omitempty"` // 静态upstream配置
+
+	// 容器名称匹配模式，默认为glob，以 regex: 开头时为正则表达式
+	ContainerNamePattern string `yaml:"container_name_pattern,omitempty"`
+	// 按docker compose项目和服务匹配容器
+	ComposeProject string `yaml:"compose_project,omitempty"`
+	ComposeService string `yaml:"compose_service,omitempty"`
+
+	// 容器IP所在的网络，可以是单个名称或按优先级排列的列表
+	Network StringList `yaml:"network,omitempty"`
+
+	// 上游服务器使用的IP协议族: ipv4（默认）、ipv6、dual
+	IPFamily string `yaml:"ip_family,omitempty"`
+
+	// 上游服务器地址模式: ip、dns、host_port，为空时使用全局配置
+	UpstreamAddressMode string `yaml:"upstream_address_mode,omitempty"`
+
+	// Stream服务的协议: tcp（默认）、udp、both
+	Protocol string `yaml:"protocol,omitempty"`
+	// UDP代理期望上游服务器返回的数据报数量，如DNS为1、syslog为0，默认不限制
+	ProxyResponses *int `yaml:"proxy_responses,omitempty"`
+	// UDP代理的超时时间，默认10s
+	ProxyTimeout time.Duration `yaml:"proxy_timeout,omitempty"`
+
+	// 负载均衡和上游服务器参数
+	Upstream *UpstreamConfig `yaml:"upstream,omitempty"`
+
+	// 容器停止时摘除流量的等待时间，为空时使用全局配置
+	DrainPeriod time.Duration `yaml:"drain_period,omitempty"`
+
+	// 各轨道的流量百分比，如 canary: 10，未列出的轨道分配剩余的流量
+	Tracks map[string]int `yaml:"tracks,omitempty"`
+
+	// 覆盖全局的证书和HTTPS配置，仅HTTP服务
+	TLS *TLSConfig `yaml:"tls,omitempty"`
+
+	// 没有上游服务器时的处理方式，为空时使用全局配置，仅HTTP服务
+	OnEmpty string `yaml:"on_empty,omitempty"`
+	// 覆盖全局的维护页面配置
+	Maintenance *MaintenanceConfig `yaml:"maintenance,omitempty"`
+
+	// 容器配置了HEALTHCHECK时，是否只在健康时加入上游服务器，默认为 true
+	RequireHealthy *bool `yaml:"require_healthy,omitempty"`
+	// 容器没有配置HEALTHCHECK时，启动后等待该时间再加入上游服务器
+	HealthGracePeriod time.Duration `yaml:"health_grace_period,omitempty"`
+}
+
+// StringList 字符串列表，配置文件中可以写成单个字符串或字符串列表
+type StringList []string
+
+// UnmarshalYAML 支持单个字符串和字符串列表两种写法
+func (l *StringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var item string
+		if err := value.Decode(&item); err != nil {
+			return err
+		}
+		if item == "" {
+			*l = nil
+		} else {
+			*l = StringList{item}
+		}
+		return nil
+	}
+
+	var items []string
+	if err := value.Decode(&items); err != nil {
+		return err
+	}
+	*l = items
+	return nil
 }
 
 // ProxyConfig 代理配置
@@ -63,6 +229,50 @@ type ProxyConfig struct {
 	ProxyRedirect     string   `yaml:"proxy_redirect,omitempty"`
 }
 
+// 负载均衡方式
+const (
+	BalanceRoundRobin = "round_robin"
+	BalanceLeastConn  = "least_conn"
+	BalanceIPHash     = "ip_hash"
+	BalanceHash       = "hash"
+	BalanceRandom     = "random"
+)
+
+// UpstreamConfig 上游服务器组的负载均衡配置
+type UpstreamConfig struct {
+	// 负载均衡方式: round_robin（默认）、least_conn、ip_hash（仅HTTP）、hash、random
+	Method string `yaml:"method,omitempty"`
+	// hash 方式使用的键，如 $request_uri、$remote_addr
+	HashKey string `yaml:"hash_key,omitempty"`
+	// hash 方式是否使用一致性哈希
+	Consistent bool `yaml:"consistent,omitempty"`
+	// 每个worker保持的空闲长连接数量（仅HTTP）
+	Keepalive int `yaml:"keepalive,omitempty"`
+	// 每个上游服务器的默认参数，可以通过容器标签覆盖
+	ServerOptions `yaml:",inline"`
+}
+
+// ServerOptions 上游服务器参数，未配置时使用nginx默认值
+type ServerOptions struct {
+	Weight      int           `yaml:"weight,omitempty"`
+	MaxFails    *int          `yaml:"max_fails,omitempty"`
+	FailTimeout time.Duration `yaml:"fail_timeout,omitempty"`
+	Backup      bool          `yaml:"backup,omitempty"`
+	// 容器所属的发布轨道，由容器标签 docker-tool.track 设置
+	Track string `yaml:"-"`
+}
+
+// Equal 上游服务器参数是否相同
+func (o ServerOptions) Equal(other ServerOptions) bool {
+	if (o.MaxFails == nil) != (other.MaxFails == nil) || (o.MaxFails != nil && *o.MaxFails != *other.MaxFails) {
+		return false
+	}
+	return o.Weight == other.Weight && o.FailTimeout == other.FailTimeout && o.Backup == other.Backup && o.Track == other.Track
+}
+
+// DefaultTrack 容器没有设置 docker-tool.track 标签时所属的轨道
+const DefaultTrack = "stable"
+
 // Load 加载配置文件
 func Load(filename string) (*Config, error) {
 	data, err := os.ReadFile(filename)
@@ -112,17 +322,112 @@ func (c *Config) HasChanged() bool {
 
 // Validate 验证配置
 func (c *Config) Validate() error {
-	// 只验证全局配置，服务配置在运行时验证
+	// 只验证全局配置和服务之间的冲突，单个服务配置在运行时验证
 	if c.Global.NginxConfigDir == "" {
 		return fmt.Errorf("nginx_config_dir 不能为空")
 	}
 	if c.Global.StreamConfigDir == "" {
 		return fmt.Errorf("stream_config_dir 不能为空")
 	}
-	if c.Global.NginxReloadCmd == "" {
-		return fmt.Errorf("nginx_reload_cmd 不能为空")
+	if err := c.Global.Reload.validate(c.Global.NginxReloadCmd); err != nil {
+		return err
+	}
+	if !validAddressMode(c.Global.UpstreamAddressMode) {
+		return fmt.Errorf("upstream_address_mode 必须是 ip、dns 或 host_port")
+	}
+	if !validOnEmpty(c.Global.OnEmpty) {
+		return fmt.Errorf("on_empty 必须是 delete、maintenance 或 keep")
+	}
+	if err := c.Global.Maintenance.validate(); err != nil {
+		return fmt.Errorf("maintenance 配置无效: %w", err)
+	}
+	if err := c.checkServiceConflicts(); err != nil {
+		return err
+	}
+	if err := c.checkCertificates(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkServiceConflicts 检查服务生成的upstream名称，以及HTTP服务的域名和路径是否重复
+// HTTP和Stream的upstream在nginx的不同上下文中，只检查同类型的服务
+// 同一域名的HTTP服务生成在一个server块中，TLS配置必须相同，域名别名不能属于其他域名
+func (c *Config) checkServiceConflicts() error {
+	owners := make(map[string]string)
+	routes := make(map[string]string)
+	domainTLS := make(map[string]*ServiceConfig)
+	serverNames := make(map[string]*ServiceConfig)
+	for i := range c.Services {
+		service := &c.Services[i]
+		for _, name := range UpstreamNames(service) {
+			key := service.Type + "/" + name
+			if owner, exists := owners[key]; exists {
+				return fmt.Errorf("服务 %s 的upstream名称 %s 与服务 %s 冲突", service.Name, name, owner)
+			}
+			owners[key] = service.Name
+		}
+
+		// 同一域名下的服务生成在一个server块中，路径不能重复
+		if service.Type == "http" {
+			route := service.Domain + service.Path
+			if owner, exists := routes[route]; exists {
+				return fmt.Errorf("服务 %s 的 %s 与服务 %s 冲突", service.Name, route, owner)
+			}
+			routes[route] = service.Name
+
+			if other, exists := domainTLS[service.Domain]; exists && c.TLS(other) != c.TLS(service) {
+				return fmt.Errorf("服务 %s 与服务 %s 使用同一域名 %s，TLS配置必须相同", service.Name, other.Name, service.Domain)
+			}
+			domainTLS[service.Domain] = service
+
+			for _, name := range service.ServerNames() {
+				if other, exists := serverNames[name]; exists && other.Domain != service.Domain {
+					return fmt.Errorf("服务 %s 的域名 %s 已被域名 %s 的服务 %s 使用", service.Name, name, other.Domain, other.Name)
+				}
+				serverNames[name] = service
+			}
+		}
+	}
+	return nil
+}
+
+// UpstreamNames 获取服务生成的所有upstream名称，包括SNI服务的 static_upstreams
+func UpstreamNames(service *ServiceConfig) []string {
+	var names []string
+	if service.UpstreamName != "" {
+		names = append(names, service.UpstreamName)
 	}
+	if service.Type == "stream" {
+		staticNames := make([]string, 0, len(service.StaticUpstreams))
+		for name := range service.StaticUpstreams {
+			staticNames = append(staticNames, name)
+		}
+		sort.Strings(staticNames)
+		names = append(names, staticNames...)
+	}
+	return names
+}
 
+// validate 验证nginx重载方式配置
+func (r *ReloadConfig) validate(legacyCmd string) error {
+	switch r.Mode {
+	case "", "command":
+		if len(r.Command) == 0 && strings.TrimSpace(legacyCmd) == "" {
+			return fmt.Errorf("nginx_reload_cmd 和 reload.command 不能同时为空")
+		}
+	case "docker_exec", "docker_signal":
+		if r.Container == "" {
+			return fmt.Errorf("reload.mode 为 %s 时 reload.container 不能为空", r.Mode)
+		}
+	case "pidfile":
+		if r.PIDFile == "" {
+			return fmt.Errorf("reload.mode 为 pidfile 时 reload.pid_file 不能为空")
+		}
+	default:
+		return fmt.Errorf("reload.mode 必须是 command、docker_exec、docker_signal 或 pidfile")
+	}
 	return nil
 }
 
@@ -135,19 +440,78 @@ func (c *Config) ValidateService(service *ServiceConfig) error {
 		return fmt.Errorf("服务 %s 的 type 必须是 http 或 stream", service.Name)
 	}
 	// 对于启用SNI的stream服务，不强制要求container_name
-	if service.ContainerName == "" && !(service.Type == "stream" && service.EnableSNI) {
-		return fmt.Errorf("服务 %s 的 container_name 不能为空", service.Name)
+	if !service.hasContainerMatcher() && !(service.Type == "stream" && service.EnableSNI) {
+		return fmt.Errorf("服务 %s 的 container_name、container_name_pattern、compose_service 不能同时为空", service.Name)
+	}
+	if service.ContainerNamePattern != "" {
+		if _, err := service.matchContainerNamePattern(""); err != nil {
+			return fmt.Errorf("服务 %s 的 container_name_pattern 无效: %w", service.Name, err)
+		}
+	}
+	switch service.IPFamily {
+	case "", IPFamilyIPv4, IPFamilyIPv6, IPFamilyDual:
+	default:
+		return fmt.Errorf("服务 %s 的 ip_family 必须是 ipv4、ipv6 或 dual", service.Name)
+	}
+	if !validAddressMode(service.UpstreamAddressMode) {
+		return fmt.Errorf("服务 %s 的 upstream_address_mode 必须是 ip、dns 或 host_port", service.Name)
+	}
+	if service.ComposeProject != "" && service.ComposeService == "" {
+		return fmt.Errorf("服务 %s 配置了 compose_project 时 compose_service 不能为空", service.Name)
 	}
 	if service.UpstreamName == "" {
 		return fmt.Errorf("服务 %s 的 upstream_name 不能为空", service.Name)
 	}
+	if service.Upstream != nil {
+		if err := service.Upstream.validate(service.Type); err != nil {
+			return fmt.Errorf("服务 %s 的 upstream 配置无效: %w", service.Name, err)
+		}
+	}
+	if len(service.Tracks) > 0 {
+		total := 0
+		for track, percent := range service.Tracks {
+			if track == "" || percent < 0 || percent > 100 {
+				return fmt.Errorf("服务 %s 的 tracks 配置无效: %s: %d", service.Name, track, percent)
+			}
+			total += percent
+		}
+		if total > 100 {
+			return fmt.Errorf("服务 %s 的 tracks 流量百分比之和不能超过100", service.Name)
+		}
+	}
 
 	if service.Type == "http" {
+		if service.Protocol != "" && service.Protocol != ProtocolTCP {
+			return fmt.Errorf("HTTP服务 %s 的 protocol 只能是 tcp", service.Name)
+		}
 		if service.Domain == "" {
 			return fmt.Errorf("HTTP服务 %s 的 domain 不能为空", service.Name)
 		}
-		if service.Port == 0 {
-			return fmt.Errorf("HTTP服务 %s 的 port 不能为空", service.Name)
+		// port 为空时根据容器暴露的端口自动检测
+		if service.Port < 0 || service.Port > 65535 {
+			return fmt.Errorf("HTTP服务 %s 的 port 无效: %d", service.Name, service.Port)
+		}
+		for _, name := range service.Domains {
+			if name == "" || name == service.Domain || strings.ContainsAny(name, " ;{}") {
+				return fmt.Errorf("HTTP服务 %s 的 domains 中的域名无效: %q", service.Name, name)
+			}
+		}
+		if service.TLS != nil {
+			if err := service.TLS.validate(); err != nil {
+				return fmt.Errorf("HTTP服务 %s 的 tls 配置无效: %w", service.Name, err)
+			}
+		}
+		if !validOnEmpty(service.OnEmpty) {
+			return fmt.Errorf("HTTP服务 %s 的 on_empty 必须是 delete、maintenance 或 keep", service.Name)
+		}
+		// nginx只在加载配置时解析容器名称，已停止的容器名称无法解析，保留的上游服务器会使配置测试失败
+		if c.OnEmpty(service) == OnEmptyKeep && c.AddressMode(service) == AddressModeDNS {
+			return fmt.Errorf("HTTP服务 %s 使用 dns 地址模式时 on_empty 不能是 keep", service.Name)
+		}
+		if service.Maintenance != nil {
+			if err := service.Maintenance.validate(); err != nil {
+				return fmt.Errorf("HTTP服务 %s 的 maintenance 配置无效: %w", service.Name, err)
+			}
 		}
 	}
 
@@ -155,9 +519,30 @@ func (c *Config) ValidateService(service *ServiceConfig) error {
 		if service.ListenPort == 0 {
 			return fmt.Errorf("Stream服务 %s 的 listen_port 不能为空", service.Name)
 		}
-		if service.ContainerPort == 0 {
+		// container_port 为空时根据容器暴露的端口自动检测，SNI服务的默认后端没有容器，必须配置
+		if service.ContainerPort == 0 && service.EnableSNI {
 			return fmt.Errorf("Stream服务 %s 的 container_port 不能为空", service.Name)
 		}
+		if service.ContainerPort < 0 || service.ContainerPort > 65535 {
+			return fmt.Errorf("Stream服务 %s 的 container_port 无效: %d", service.Name, service.ContainerPort)
+		}
+		switch service.Protocol {
+		case "", ProtocolTCP, ProtocolUDP, ProtocolBoth:
+		default:
+			return fmt.Errorf("Stream服务 %s 的 protocol 必须是 tcp、udp 或 both", service.Name)
+		}
+		if service.EnableSNI && service.StreamProtocol() != ProtocolTCP {
+			return fmt.Errorf("Stream服务 %s 启用SNI时 protocol 只能是 tcp", service.Name)
+		}
+		if service.ProxyResponses != nil && *service.ProxyResponses < 0 {
+			return fmt.Errorf("Stream服务 %s 的 proxy_responses 不能小于0", service.Name)
+		}
+		if service.OnEmpty != "" || service.Maintenance != nil {
+			return fmt.Errorf("Stream服务 %s 不支持 on_empty 和 maintenance", service.Name)
+		}
+		if len(service.Domains) > 0 || service.TLS != nil {
+			return fmt.Errorf("Stream服务 %s 不支持 domains 和 tls", service.Name)
+		}
 	}
 
 	return nil
@@ -171,9 +556,191 @@ func (c *Config) GetServiceByContainerName(containerName string) *ServiceConfig
 	for _, service := range c.Services {
 		// 也去掉配置中的容器名称前的 / 符号进行比较
 		configName := strings.TrimPrefix(service.ContainerName, "/")
-		if configName == normalizedName {
+		if configName != "" && configName == normalizedName {
+			return &service
+		}
+	}
+	return nil
+}
+
+// GetServiceByContainer 根据容器名称和标签获取服务配置
+// 优先精确匹配容器名称，其次按 container_name_pattern 和 compose 项目/服务匹配
+func (c *Config) GetServiceByContainer(containerName string, labels map[string]string) *ServiceConfig {
+	if service := c.GetServiceByContainerName(containerName); service != nil {
+		return service
+	}
+
+	normalizedName := strings.TrimPrefix(containerName, "/")
+	for _, service := range c.Services {
+		if service.ContainerNamePattern != "" {
+			matched, err := service.matchContainerNamePattern(normalizedName)
+			if err == nil && matched {
+				return &service
+			}
+		}
+		if service.ComposeService != "" && service.matchCompose(labels) {
 			return &service
 		}
 	}
 	return nil
 }
+
+// RequiresHealthy 容器配置了HEALTHCHECK时是否只在健康时加入上游服务器
+func (s *ServiceConfig) RequiresHealthy() bool {
+	return s.RequireHealthy == nil || *s.RequireHealthy
+}
+
+// validate 验证负载均衡配置，serviceType 为服务类型
+func (u *UpstreamConfig) validate(serviceType string) error {
+	switch u.Method {
+	case "", BalanceRoundRobin, BalanceLeastConn, BalanceRandom:
+	case BalanceIPHash:
+		if serviceType != "http" {
+			return fmt.Errorf("ip_hash 只能用于HTTP服务")
+		}
+	case BalanceHash:
+		if u.HashKey == "" {
+			return fmt.Errorf("method 为 hash 时 hash_key 不能为空")
+		}
+	default:
+		return fmt.Errorf("method 必须是 round_robin、least_conn、ip_hash、hash 或 random")
+	}
+	if u.Keepalive < 0 {
+		return fmt.Errorf("keepalive 不能小于0")
+	}
+	if u.Keepalive > 0 && serviceType != "http" {
+		return fmt.Errorf("keepalive 只能用于HTTP服务")
+	}
+	return u.ValidateServerOptions(u.ServerOptions)
+}
+
+// ValidateServerOptions 验证上游服务器参数是否可以用于该负载均衡方式
+func (u *UpstreamConfig) ValidateServerOptions(options ServerOptions) error {
+	if options.Weight < 0 {
+		return fmt.Errorf("weight 不能小于0")
+	}
+	if options.MaxFails != nil && *options.MaxFails < 0 {
+		return fmt.Errorf("max_fails 不能小于0")
+	}
+	if options.FailTimeout < 0 {
+		return fmt.Errorf("fail_timeout 不能小于0")
+	}
+	if options.Backup {
+		switch u.Method {
+		case BalanceIPHash, BalanceHash, BalanceRandom:
+			return fmt.Errorf("backup 不能用于 %s 负载均衡方式", u.Method)
+		}
+	}
+	return nil
+}
+
+// StreamProtocol 获取服务的协议，未配置时为 tcp
+func (s *ServiceConfig) StreamProtocol() string {
+	if s.Protocol == "" {
+		return ProtocolTCP
+	}
+	return s.Protocol
+}
+
+// AddressMode 获取服务的上游服务器地址模式，未配置时使用全局配置，都为空时返回空字符串
+func (c *Config) AddressMode(service *ServiceConfig) string {
+	if service.UpstreamAddressMode != "" {
+		return service.UpstreamAddressMode
+	}
+	return c.Global.UpstreamAddressMode
+}
+
+// DrainPeriod 获取服务的摘除流量等待时间，未配置时使用全局配置
+func (c *Config) DrainPeriod(service *ServiceConfig) time.Duration {
+	if service != nil && service.DrainPeriod > 0 {
+		return service.DrainPeriod
+	}
+	return c.Global.DrainPeriod
+}
+
+// OnEmpty 获取HTTP服务没有上游服务器时的处理方式，未配置时使用全局配置，默认为 delete
+func (c *Config) OnEmpty(service *ServiceConfig) string {
+	if service.OnEmpty != "" {
+		return service.OnEmpty
+	}
+	if c.Global.OnEmpty != "" {
+		return c.Global.OnEmpty
+	}
+	return OnEmptyDelete
+}
+
+// Maintenance 获取服务的维护页面配置，未配置时使用全局配置
+func (c *Config) Maintenance(service *ServiceConfig) MaintenanceConfig {
+	if service.Maintenance != nil {
+		return *service.Maintenance
+	}
+	return c.Global.Maintenance
+}
+
+// validate 验证维护页面配置
+func (m *MaintenanceConfig) validate() error {
+	if m.Page != "" && m.Redirect != "" {
+		return fmt.Errorf("page 和 redirect 不能同时配置")
+	}
+	if m.Page != "" && !path.IsAbs(m.Page) {
+		return fmt.Errorf("page 必须是绝对路径: %s", m.Page)
+	}
+	if strings.ContainsAny(m.Page+m.Redirect, " ;{}\"'") {
+		return fmt.Errorf("page 和 redirect 不能包含空格、引号、分号或大括号")
+	}
+	return nil
+}
+
+// NginxContainerName 获取nginx所在的容器名称，未配置时返回空字符串
+func (c *Config) NginxContainerName() string {
+	if c.Global.NginxContainer != "" {
+		return c.Global.NginxContainer
+	}
+	switch c.Global.Reload.Mode {
+	case "docker_exec", "docker_signal":
+		return c.Global.Reload.Container
+	}
+	return ""
+}
+
+// validAddressMode 检查上游服务器地址模式是否有效
+func validAddressMode(mode string) bool {
+	switch mode {
+	case "", AddressModeIP, AddressModeDNS, AddressModeHostPort:
+		return true
+	}
+	return false
+}
+
+func validOnEmpty(onEmpty string) bool {
+	switch onEmpty {
+	case "", OnEmptyDelete, OnEmptyMaintenance, OnEmptyKeep:
+		return true
+	}
+	return false
+}
+
+// hasContainerMatcher 服务是否配置了任意一种容器匹配方式
+func (s *ServiceConfig) hasContainerMatcher() bool {
+	return s.ContainerName != "" || s.ContainerNamePattern != "" || s.ComposeService != ""
+}
+
+// matchContainerNamePattern 检查容器名称是否匹配 container_name_pattern
+func (s *ServiceConfig) matchContainerNamePattern(containerName string) (bool, error) {
+	if expr, isRegex := strings.CutPrefix(s.ContainerNamePattern, "regex:"); isRegex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(containerName), nil
+	}
+	return path.Match(s.ContainerNamePattern, containerName)
+}
+
+// matchCompose 检查容器的compose标签是否匹配 compose_project 和 compose_service
+func (s *ServiceConfig) matchCompose(labels map[string]string) bool {
+	if labels[ComposeServiceLabel] != s.ComposeService {
+		return false
+	}
+	return s.ComposeProject == "" || labels[ComposeProjectLabel] == s.ComposeProject
+}
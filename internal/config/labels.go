@@ -0,0 +1,274 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// 容器标签前缀，容器可以通过这些标签声明自己的服务配置
+const LabelPrefix = "docker-tool."
+
+// 支持的容器标签
+const (
+	LabelName                   = LabelPrefix + "name"
+	LabelUpstreamName           = LabelPrefix + "upstream_name"
+	LabelPort                   = LabelPrefix + "port"
+	LabelHTTPDomain             = LabelPrefix + "http.domain"
+	LabelHTTPDomains            = LabelPrefix + "http.domains"
+	LabelHTTPPath               = LabelPrefix + "http.path"
+	LabelHTTPPort               = LabelPrefix + "http.port"
+	LabelStreamListenPort       = LabelPrefix + "stream.listen_port"
+	LabelStreamContainerPort    = LabelPrefix + "stream.container_port"
+	LabelStreamProtocol         = LabelPrefix + "stream.protocol"
+	LabelProxyClientMaxBodySize = LabelPrefix + "proxy.client_max_body_size"
+	LabelProxyEnableWebSocket   = LabelPrefix + "proxy.enable_websocket"
+	LabelProxyHTTPVersion       = LabelPrefix + "proxy.proxy_http_version"
+	LabelProxyRedirect          = LabelPrefix + "proxy.proxy_redirect"
+	LabelWeight                 = LabelPrefix + "weight"
+	LabelMaxFails               = LabelPrefix + "max_fails"
+	LabelFailTimeout            = LabelPrefix + "fail_timeout"
+	LabelBackup                 = LabelPrefix + "backup"
+	LabelTrack                  = LabelPrefix + "track"
+)
+
+// 会写入nginx配置的标签，值只能是一个nginx参数
+var renderedLabels = []string{
+	LabelName,
+	LabelUpstreamName,
+	LabelHTTPDomain,
+	LabelHTTPPath,
+	LabelProxyClientMaxBodySize,
+	LabelProxyHTTPVersion,
+	LabelProxyRedirect,
+}
+
+// 会用作配置文件名或upstream名称的标签，不能包含 /
+var nameLabels = []string{LabelName, LabelUpstreamName, LabelHTTPDomain}
+
+// ServiceFromLabels 根据容器标签生成服务配置
+// 容器没有声明任何服务标签时返回 nil
+func (c *Config) ServiceFromLabels(containerName string, labels map[string]string) (*ServiceConfig, error) {
+	name := strings.TrimPrefix(containerName, "/")
+	if err := checkLabelValues(labels); err != nil {
+		return nil, fmt.Errorf("容器 %s 的%w", name, err)
+	}
+
+	service := &ServiceConfig{
+		Name:          name,
+		ContainerName: name,
+	}
+	if value := labels[LabelName]; value != "" {
+		service.Name = value
+	}
+
+	_, isHTTP := labels[LabelHTTPDomain]
+	_, isStream := labels[LabelStreamListenPort]
+	switch {
+	case isHTTP && isStream:
+		return nil, fmt.Errorf("容器 %s 不能同时声明 http 和 stream 标签", name)
+	case isHTTP:
+		service.Type = "http"
+		service.Domain = labels[LabelHTTPDomain]
+		for _, name := range strings.Split(labels[LabelHTTPDomains], ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				service.Domains = append(service.Domains, name)
+			}
+		}
+		service.Path = labels[LabelHTTPPath]
+		if service.Path == "" {
+			service.Path = "/"
+		}
+		port, err := parseIntLabel(labels, LabelHTTPPort)
+		if err != nil {
+			return nil, err
+		}
+		service.Port = port
+	case isStream:
+		service.Type = "stream"
+		listenPort, err := parseIntLabel(labels, LabelStreamListenPort)
+		if err != nil {
+			return nil, err
+		}
+		containerPort, err := parseIntLabel(labels, LabelStreamContainerPort)
+		if err != nil {
+			return nil, err
+		}
+		service.ListenPort = listenPort
+		service.ContainerPort = containerPort
+		service.Protocol = labels[LabelStreamProtocol]
+	default:
+		return nil, nil
+	}
+
+	service.UpstreamName = labels[LabelUpstreamName]
+	if service.UpstreamName == "" {
+		service.UpstreamName = strings.NewReplacer("-", "_", ".", "_").Replace(service.Name) + "_backend"
+	}
+
+	proxyConfig, err := c.proxyConfigFromLabels(labels)
+	if err != nil {
+		return nil, err
+	}
+	service.ProxyConfig = proxyConfig
+
+	// YAML中的服务优先
+	if err := c.checkLabelServiceConflict(service); err != nil {
+		return nil, err
+	}
+
+	return service, nil
+}
+
+// proxyConfigFromLabels 根据容器标签生成代理配置，在全局默认代理配置的基础上覆盖
+func (c *Config) proxyConfigFromLabels(labels map[string]string) (*ProxyConfig, error) {
+	hasProxyLabel := false
+	for key := range labels {
+		if strings.HasPrefix(key, LabelPrefix+"proxy.") {
+			hasProxyLabel = true
+			break
+		}
+	}
+	if !hasProxyLabel {
+		return nil, nil
+	}
+
+	proxyConfig := c.Global.DefaultProxy
+	proxyConfig.ProxyHeaders = append([]string(nil), c.Global.DefaultProxy.ProxyHeaders...)
+
+	if value, exists := labels[LabelProxyClientMaxBodySize]; exists {
+		proxyConfig.ClientMaxBodySize = value
+	}
+	if value, exists := labels[LabelProxyEnableWebSocket]; exists {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("标签 %s 的值无效: %s", LabelProxyEnableWebSocket, value)
+		}
+		proxyConfig.EnableWebSocket = enabled
+	}
+	if value, exists := labels[LabelProxyHTTPVersion]; exists {
+		proxyConfig.ProxyHTTPVersion = value
+	}
+	if value, exists := labels[LabelProxyRedirect]; exists {
+		proxyConfig.ProxyRedirect = value
+	}
+
+	return &proxyConfig, nil
+}
+
+// ServerOptionsFromLabels 获取容器作为上游服务器的参数
+// 在服务 upstream 配置的基础上，使用容器标签覆盖 weight、max_fails、fail_timeout、backup，并设置所属的轨道
+func (s *ServiceConfig) ServerOptionsFromLabels(labels map[string]string) (ServerOptions, error) {
+	upstream := s.Upstream
+	if upstream == nil {
+		upstream = &UpstreamConfig{}
+	}
+	options := upstream.ServerOptions
+
+	if _, exists := labels[LabelWeight]; exists {
+		weight, err := parseIntLabel(labels, LabelWeight)
+		if err != nil {
+			return ServerOptions{}, err
+		}
+		options.Weight = weight
+	}
+	if _, exists := labels[LabelMaxFails]; exists {
+		maxFails, err := parseIntLabel(labels, LabelMaxFails)
+		if err != nil {
+			return ServerOptions{}, err
+		}
+		options.MaxFails = &maxFails
+	}
+	if value, exists := labels[LabelFailTimeout]; exists {
+		failTimeout, err := time.ParseDuration(value)
+		if err != nil {
+			return ServerOptions{}, fmt.Errorf("标签 %s 的值无效: %s", LabelFailTimeout, value)
+		}
+		options.FailTimeout = failTimeout
+	}
+	if value, exists := labels[LabelBackup]; exists {
+		backup, err := strconv.ParseBool(value)
+		if err != nil {
+			return ServerOptions{}, fmt.Errorf("标签 %s 的值无效: %s", LabelBackup, value)
+		}
+		options.Backup = backup
+	}
+
+	options.Track = labels[LabelTrack]
+	if options.Track == "" {
+		options.Track = DefaultTrack
+	}
+
+	if err := upstream.ValidateServerOptions(options); err != nil {
+		return ServerOptions{}, fmt.Errorf("容器标签配置的上游服务器参数无效: %w", err)
+	}
+	return options, nil
+}
+
+// checkLabelServiceConflict 检查标签声明的服务是否与YAML中的服务冲突
+func (c *Config) checkLabelServiceConflict(service *ServiceConfig) error {
+	for _, existing := range c.Services {
+		if existing.Name == service.Name {
+			return fmt.Errorf("标签服务 %s 与配置文件中的服务同名", service.Name)
+		}
+		if existing.Type == "http" && service.Type == "http" &&
+			existing.Domain == service.Domain && existing.Path == service.Path {
+			return fmt.Errorf("标签服务 %s 的 %s%s 已被配置文件中的服务 %s 使用", service.Name, service.Domain, service.Path, existing.Name)
+		}
+		if existing.Type == "stream" && service.Type == "stream" && existing.ListenPort == service.ListenPort {
+			return fmt.Errorf("标签服务 %s 的监听端口 %d 已被配置文件中的服务 %s 使用", service.Name, service.ListenPort, existing.Name)
+		}
+		if existing.Type == service.Type {
+			for _, name := range UpstreamNames(&existing) {
+				if name == service.UpstreamName {
+					return fmt.Errorf("标签服务 %s 的upstream名称 %s 已被配置文件中的服务 %s 使用", service.Name, name, existing.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkLabelValues 检查会写入nginx配置的标签值，不能包含空白字符、分号、花括号、引号等字符，
+// 避免只能修改compose文件的使用者通过标签注入额外的nginx指令
+func checkLabelValues(labels map[string]string) error {
+	for _, key := range renderedLabels {
+		if value, exists := labels[key]; exists && !validLabelValue(value) {
+			return fmt.Errorf("标签 %s 的值无效: %q", key, value)
+		}
+	}
+	for _, name := range strings.Split(labels[LabelHTTPDomains], ",") {
+		if name = strings.TrimSpace(name); !validLabelValue(name) || strings.Contains(name, "/") {
+			return fmt.Errorf("标签 %s 的值无效: %q", LabelHTTPDomains, name)
+		}
+	}
+	for _, key := range nameLabels {
+		if strings.Contains(labels[key], "/") {
+			return fmt.Errorf("标签 %s 的值不能包含 /: %q", key, labels[key])
+		}
+	}
+	if path, exists := labels[LabelHTTPPath]; exists && path != "" && !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("标签 %s 的值必须以 / 开头: %q", LabelHTTPPath, path)
+	}
+	return nil
+}
+
+// validLabelValue 标签值是否只包含一个nginx参数
+func validLabelValue(value string) bool {
+	return !strings.ContainsFunc(value, unicode.IsSpace) && !strings.ContainsAny(value, ";{}'\"\\#")
+}
+
+// parseIntLabel 解析整数类型的标签，标签不存在时返回0
+func parseIntLabel(labels map[string]string, key string) (int, error) {
+	value, exists := labels[key]
+	if !exists || value == "" {
+		return 0, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("标签 %s 的值无效: %s", key, value)
+	}
+	return number, nil
+}
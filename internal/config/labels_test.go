@@ -0,0 +1,183 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServiceFromLabels(t *testing.T) {
+	cfg := &Config{
+		Global: GlobalConfig{
+			DefaultProxy: ProxyConfig{ClientMaxBodySize: "10M", ProxyHeaders: []string{"Host $host"}},
+		},
+		Services: []ServiceConfig{
+			{Name: "yaml", Type: "http", Domain: "yaml.example.com", Path: "/", UpstreamName: "yaml_backend"},
+			{Name: "mysql", Type: "stream", ListenPort: 3306, UpstreamName: "mysql_backend"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		want    *ServiceConfig
+		wantErr bool
+	}{
+		{
+			name:   "没有服务标签",
+			labels: map[string]string{"com.docker.compose.service": "api", LabelWeight: "2"},
+		},
+		{
+			name: "HTTP服务",
+			labels: map[string]string{
+				LabelHTTPDomain:  "api.example.com",
+				LabelHTTPDomains: "www.example.com, *.api.example.com",
+				LabelHTTPPort:    "9000",
+			},
+			want: &ServiceConfig{
+				Name:          "api-1",
+				ContainerName: "api-1",
+				Type:          "http",
+				Domain:        "api.example.com",
+				Domains:       []string{"www.example.com", "*.api.example.com"},
+				Path:          "/",
+				Port:          9000,
+				UpstreamName:  "api_1_backend",
+			},
+		},
+		{
+			name: "Stream服务",
+			labels: map[string]string{
+				LabelName:                "cache",
+				LabelUpstreamName:        "redis",
+				LabelStreamListenPort:    "6379",
+				LabelStreamContainerPort: "6380",
+				LabelStreamProtocol:      "tcp",
+			},
+			want: &ServiceConfig{
+				Name:          "cache",
+				ContainerName: "api-1",
+				Type:          "stream",
+				ListenPort:    6379,
+				ContainerPort: 6380,
+				Protocol:      "tcp",
+				UpstreamName:  "redis",
+			},
+		},
+		{
+			name: "代理配置覆盖全局默认配置",
+			labels: map[string]string{
+				LabelHTTPDomain:             "api.example.com",
+				LabelHTTPPath:               "/api/",
+				LabelProxyClientMaxBodySize: "100M",
+				LabelProxyEnableWebSocket:   "true",
+				LabelProxyRedirect:          "off",
+			},
+			want: &ServiceConfig{
+				Name:          "api-1",
+				ContainerName: "api-1",
+				Type:          "http",
+				Domain:        "api.example.com",
+				Path:          "/api/",
+				UpstreamName:  "api_1_backend",
+				ProxyConfig: &ProxyConfig{
+					ClientMaxBodySize: "100M",
+					EnableWebSocket:   true,
+					ProxyHeaders:      []string{"Host $host"},
+					ProxyRedirect:     "off",
+				},
+			},
+		},
+		{
+			name:    "同时声明HTTP和Stream",
+			labels:  map[string]string{LabelHTTPDomain: "api.example.com", LabelStreamListenPort: "6379"},
+			wantErr: true,
+		},
+		{
+			name:    "端口不是数字",
+			labels:  map[string]string{LabelHTTPDomain: "api.example.com", LabelHTTPPort: "http"},
+			wantErr: true,
+		},
+		{
+			name:    "与配置文件中的服务同名",
+			labels:  map[string]string{LabelName: "yaml", LabelHTTPDomain: "api.example.com"},
+			wantErr: true,
+		},
+		{
+			name:    "与配置文件中的服务域名和路径相同",
+			labels:  map[string]string{LabelHTTPDomain: "yaml.example.com"},
+			wantErr: true,
+		},
+		{
+			name:    "与配置文件中的服务监听端口相同",
+			labels:  map[string]string{LabelStreamListenPort: "3306"},
+			wantErr: true,
+		},
+		{
+			name:    "proxy_redirect注入指令",
+			labels:  map[string]string{LabelHTTPDomain: "api.example.com", LabelProxyRedirect: "off; } location /x { alias /etc/; "},
+			wantErr: true,
+		},
+		{
+			name:    "client_max_body_size包含分号",
+			labels:  map[string]string{LabelHTTPDomain: "api.example.com", LabelProxyClientMaxBodySize: "1M;"},
+			wantErr: true,
+		},
+		{
+			name:    "proxy_http_version包含引号",
+			labels:  map[string]string{LabelHTTPDomain: "api.example.com", LabelProxyHTTPVersion: `1.1"`},
+			wantErr: true,
+		},
+		{
+			name:    "域名包含空格",
+			labels:  map[string]string{LabelHTTPDomain: "api.example.com www.example.com"},
+			wantErr: true,
+		},
+		{
+			name:    "域名包含花括号",
+			labels:  map[string]string{LabelHTTPDomain: "api.example.com{"},
+			wantErr: true,
+		},
+		{
+			name:    "域名包含斜杠",
+			labels:  map[string]string{LabelHTTPDomain: "../api.example.com"},
+			wantErr: true,
+		},
+		{
+			name:    "域名别名包含分号",
+			labels:  map[string]string{LabelHTTPDomain: "api.example.com", LabelHTTPDomains: "www.example.com;"},
+			wantErr: true,
+		},
+		{
+			name:    "路径不以斜杠开头",
+			labels:  map[string]string{LabelHTTPDomain: "api.example.com", LabelHTTPPath: "api/"},
+			wantErr: true,
+		},
+		{
+			name:    "路径包含换行",
+			labels:  map[string]string{LabelHTTPDomain: "api.example.com", LabelHTTPPath: "/api\nreturn"},
+			wantErr: true,
+		},
+		{
+			name:    "服务名称包含斜杠",
+			labels:  map[string]string{LabelName: "../cache", LabelStreamListenPort: "6379"},
+			wantErr: true,
+		},
+		{
+			name:    "upstream名称包含井号",
+			labels:  map[string]string{LabelUpstreamName: "#cache", LabelStreamListenPort: "6379"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cfg.ServiceFromLabels("/api-1", tt.labels)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ServiceFromLabels() 错误 = %v, 期望错误 %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ServiceFromLabels() = %+v, 期望 %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+)
+
+// TLSConfig 服务的TLS配置，未配置的字段使用全局配置
+type TLSConfig struct {
+	// 证书路径
+	Cert string `yaml:"cert,omitempty"`
+	// 私钥路径
+	Key string `yaml:"key,omitempty"`
+	// 是否将HTTP请求重定向到HTTPS
+	ForceHTTPS *bool `yaml:"force_https,omitempty"`
+	// 是否返回 Strict-Transport-Security 响应头
+	HSTS *bool `yaml:"hsts,omitempty"`
+}
+
+// TLSSettings 服务最终生效的TLS配置
+type TLSSettings struct {
+	Certificate    string
+	CertificateKey string
+	ForceHTTPS     bool
+	HSTS           bool
+}
+
+// Enabled 是否启用HTTPS
+func (t TLSSettings) Enabled() bool {
+	return t.Certificate != "" && t.CertificateKey != ""
+}
+
+// validate 验证服务的TLS配置
+func (t *TLSConfig) validate() error {
+	if (t.Cert == "") != (t.Key == "") {
+		return fmt.Errorf("cert 和 key 必须同时配置")
+	}
+	return nil
+}
+
+// TLS 获取服务生效的TLS配置，服务的 tls 配置覆盖全局的 ssl_certificate、ssl_certificate_key、force_https、hsts
+func (c *Config) TLS(service *ServiceConfig) TLSSettings {
+	settings := TLSSettings{
+		Certificate:    c.Global.SSLCertPath,
+		CertificateKey: c.Global.SSLKeyPath,
+		ForceHTTPS:     c.Global.ForceHTTPS,
+		HSTS:           c.Global.HSTS,
+	}
+	if service.TLS == nil {
+		return settings
+	}
+	if service.TLS.Cert != "" {
+		settings.Certificate = service.TLS.Cert
+		settings.CertificateKey = service.TLS.Key
+	}
+	if service.TLS.ForceHTTPS != nil {
+		settings.ForceHTTPS = *service.TLS.ForceHTTPS
+	}
+	if service.TLS.HSTS != nil {
+		settings.HSTS = *service.TLS.HSTS
+	}
+	return settings
+}
+
+// ServerNames 获取HTTP服务的所有域名，包括 domain 和 domains 中的别名
+func (s *ServiceConfig) ServerNames() []string {
+	names := make([]string, 0, len(s.Domains)+1)
+	names = append(names, s.Domain)
+	return append(names, s.Domains...)
+}
+
+// checkCertificates 检查启用了HTTPS的HTTP服务的证书是否包含服务的所有域名
+// 证书文件不存在时（如路径为nginx容器内的路径）跳过检查
+func (c *Config) checkCertificates() error {
+	for i := range c.Services {
+		service := &c.Services[i]
+		if service.Type != "http" || service.Domain == "" {
+			continue
+		}
+		settings := c.TLS(service)
+		if !settings.Enabled() {
+			continue
+		}
+		err := VerifyCertificate(settings.Certificate, service.ServerNames())
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Printf("警告: 证书文件 %s 不存在，跳过服务 %s 的证书域名检查", settings.Certificate, service.Name)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("服务 %s 的证书无效: %w", service.Name, err)
+		}
+	}
+	return nil
+}
+
+// VerifyCertificate 检查证书文件中的第一个证书是否包含所有域名，nginx的正则域名（以 ~ 开头）不检查
+func VerifyCertificate(certFile string, names []string) error {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+
+	var block *pem.Block
+	for {
+		block, data = pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("证书文件 %s 中没有证书", certFile)
+		}
+		if block.Type == "CERTIFICATE" {
+			break
+		}
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("解析证书文件 %s 失败: %w", certFile, err)
+	}
+
+	for _, name := range names {
+		if strings.HasPrefix(name, "~") {
+			continue
+		}
+		if err := cert.VerifyHostname(name); err != nil {
+			return fmt.Errorf("证书 %s 不包含域名 %s", certFile, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate 生成包含指定域名的自签名证书，keyFirst 为 true 时私钥写在证书前面
+func writeCertificate(t *testing.T, dir string, names []string, keyFirst bool) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	content := append(certPEM, keyPEM...)
+	if keyFirst {
+		content = append(keyPEM, certPEM...)
+	}
+	path := filepath.Join(dir, "cert.pem")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVerifyCertificate(t *testing.T) {
+	certNames := []string{"example.com", "*.example.com"}
+
+	tests := []struct {
+		name     string
+		names    []string
+		keyFirst bool
+		wantErr  bool
+	}{
+		{"包含域名", []string{"example.com"}, false, false},
+		{"通配符证书包含子域名", []string{"example.com", "www.example.com", "api.example.com"}, false, false},
+		{"通配符证书不包含多级子域名", []string{"a.b.example.com"}, false, true},
+		{"不包含域名别名", []string{"example.com", "example.org"}, false, true},
+		{"不检查正则域名", []string{"example.com", "~^.*\\.example\\.org$"}, false, false},
+		{"跳过证书前的私钥", []string{"www.example.com"}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeCertificate(t, t.TempDir(), certNames, tt.keyFirst)
+			if err := VerifyCertificate(path, tt.names); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyCertificate() 错误 = %v, 期望错误 %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyCertificateInvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	keyOnly := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(keyOnly, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte("key")}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid.pem")
+	if err := os.WriteFile(invalid, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("cert")}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		path        string
+		wantMissing bool
+	}{
+		{"证书文件不存在", filepath.Join(dir, "missing.pem"), true},
+		{"没有证书", keyOnly, false},
+		{"证书无法解析", invalid, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyCertificate(tt.path, []string{"example.com"})
+			if err == nil {
+				t.Fatal("期望返回错误")
+			}
+			// 证书文件不存在时调用方跳过检查
+			if missing := errors.Is(err, fs.ErrNotExist); missing != tt.wantMissing {
+				t.Errorf("errors.Is(err, fs.ErrNotExist) = %v, 期望 %v: %v", missing, tt.wantMissing, err)
+			}
+		})
+	}
+}
+
+func TestConfigTLS(t *testing.T) {
+	enabled, disabled := true, false
+	cfg := &Config{Global: GlobalConfig{SSLCertPath: "/certs/default.pem", SSLKeyPath: "/certs/default.key", ForceHTTPS: true}}
+
+	tests := []struct {
+		name string
+		tls  *TLSConfig
+		want TLSSettings
+	}{
+		{"使用全局配置", nil, TLSSettings{Certificate: "/certs/default.pem", CertificateKey: "/certs/default.key", ForceHTTPS: true}},
+		{"覆盖证书", &TLSConfig{Cert: "/certs/api.pem", Key: "/certs/api.key"}, TLSSettings{Certificate: "/certs/api.pem", CertificateKey: "/certs/api.key", ForceHTTPS: true}},
+		{"覆盖force_https和hsts", &TLSConfig{ForceHTTPS: &disabled, HSTS: &enabled}, TLSSettings{Certificate: "/certs/default.pem", CertificateKey: "/certs/default.key", HSTS: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.TLS(&ServiceConfig{TLS: tt.tls}); got != tt.want {
+				t.Errorf("TLS() = %+v, 期望 %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package nginx
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 生成的配置文件首行的标记，只有带此标记的文件才会被清理
+const managedMarker = "# managed-by: docker-tool"
+
+// managedHeader 生成配置文件的标记行，记录文件所属的服务（或域名）和内容哈希
+// key 为 service 或 domain
+func managedHeader(key string, name string, content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return fmt.Sprintf("%s, %s: %s, hash: %s\n", managedMarker, key, name, hex.EncodeToString(sum[:])[:12])
+}
+
+// readManagedOwner 读取配置文件的标记行，返回文件所属的类别（service 或 domain）和名称
+// 文件不是由本程序生成时返回 false
+func readManagedOwner(path string) (string, string, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return "", "", false
+	}
+	return parseManagedOwner(scanner.Text())
+}
+
+// contentOwner 读取配置内容的标记行，返回内容所属的类别和名称
+func contentOwner(content []byte) (string, string, bool) {
+	line, _, _ := strings.Cut(string(content), "\n")
+	return parseManagedOwner(line)
+}
+
+// parseManagedOwner 解析标记行，返回所属的类别和名称
+func parseManagedOwner(line string) (string, string, bool) {
+	if !strings.HasPrefix(line, managedMarker+",") {
+		return "", "", false
+	}
+
+	for _, field := range strings.Split(strings.TrimPrefix(line, managedMarker+","), ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(field), ":")
+		key = strings.TrimSpace(key)
+		if found && (key == "service" || key == "domain") {
+			return key, strings.TrimSpace(value), true
+		}
+	}
+	return "", "", false
+}
+
+// CleanupOrphans 清理不再存在的服务的配置
+// activeServices 为当前有效的服务名称到服务类型的映射，返回被清理的服务名称（HTTP配置为域名）
+func (m *Manager) CleanupOrphans(activeServices map[string]string) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	before := m.copyState()
+	removed, err := m.cleanupOrphans(activeServices)
+	if err := m.finishUpdate(before, err); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// cleanupOrphans 清理内存中和配置目录中不再存在的服务的配置
+func (m *Manager) cleanupOrphans(activeServices map[string]string) ([]string, error) {
+	var removed []string
+
+	// 清理内存中的配置和容器注册信息
+	affectedDomains := make(map[string]struct{})
+	for serviceName, httpConfig := range m.httpConfigs {
+		if activeServices[serviceName] != "http" {
+			m.forgetService(serviceName)
+			delete(m.httpConfigs, serviceName)
+			affectedDomains[httpConfig.Domain] = struct{}{}
+		}
+	}
+	for serviceName := range m.streamConfigs {
+		if activeServices[serviceName] != "stream" {
+			m.forgetService(serviceName)
+			delete(m.streamConfigs, serviceName)
+		}
+	}
+
+	// 域名下还有其他服务时重新生成配置文件，否则在下面作为孤立文件删除
+	activeDomains := make(map[string]struct{})
+	for _, httpConfig := range m.httpConfigs {
+		activeDomains[httpConfig.Domain] = struct{}{}
+	}
+	for domain := range affectedDomains {
+		if _, exists := activeDomains[domain]; !exists {
+			continue
+		}
+		if err := m.generateHTTPDomain(domain); err != nil {
+			return removed, err
+		}
+		removed = append(removed, domain)
+	}
+
+	// 清理配置目录中由本程序生成的文件
+	// HTTP配置按域名生成，Stream配置按服务生成，带标记的按服务生成的HTTP配置文件也会被清理（没有标记的旧版本文件见 WarnLegacyHTTPFiles）
+	isActive := func(key string, name string) bool {
+		switch key {
+		case "domain":
+			_, exists := activeDomains[name]
+			return exists
+		case "service":
+			return activeServices[name] == "stream"
+		}
+		return false
+	}
+	httpRemoved, err := m.cleanupDir(m.config.Global.NginxConfigDir, isActive)
+	removed = append(removed, httpRemoved...)
+	if err != nil {
+		return removed, err
+	}
+	streamRemoved, err := m.cleanupDir(m.config.Global.StreamConfigDir, isActive)
+	removed = append(removed, streamRemoved...)
+	return removed, err
+}
+
+// WarnLegacyHTTPFiles 检查旧版本按服务生成的HTTP配置文件（<服务名称>.conf），返回找到的文件
+// 旧版本生成的文件没有标记，不会被覆盖或清理，其中的 server_name 和 upstream 可能与新生成的配置重复
+func (m *Manager) WarnLegacyHTTPFiles(serviceNames []string) []string {
+	var found []string
+	for _, serviceName := range serviceNames {
+		path := filepath.Join(m.config.Global.NginxConfigDir, serviceName+".conf")
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if _, _, managed := readManagedOwner(path); managed {
+			continue
+		}
+		log.Printf("警告: 发现没有docker-tool标记的HTTP配置文件 %s，可能是旧版本为服务 %s 生成的配置，"+
+			"其中的upstream和server_name会与按域名生成的配置重复（upstream重名时nginx配置测试失败），确认后请手动删除", path, serviceName)
+		found = append(found, path)
+	}
+	return found
+}
+
+// forgetService 移除服务的所有容器注册信息
+func (m *Manager) forgetService(serviceName string) {
+	for containerID, entry := range m.containers {
+		if entry.ServiceName == serviceName {
+			delete(m.containers, containerID)
+		}
+	}
+}
+
+// cleanupDir 删除目录中由本程序生成且 isActive 返回 false 的配置文件
+func (m *Manager) cleanupDir(dir string, isActive func(key string, name string) bool) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if err != nil {
+		return nil, fmt.Errorf("扫描配置目录失败 [%s]: %w", dir, err)
+	}
+
+	var removed []string
+	for _, path := range paths {
+		key, name, managed := readManagedOwner(path)
+		if !managed || isActive(key, name) {
+			continue
+		}
+
+		if err := m.removeConfigFile(path); err != nil {
+			return removed, fmt.Errorf("删除孤立配置文件失败 [%s]: %w", path, err)
+		}
+		log.Printf("已删除孤立配置文件: %s [%s: %s]", path, key, name)
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
@@ -0,0 +1,140 @@
+package nginx
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"docker-tool/internal/config"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCleanupOrphans(t *testing.T) {
+	m := newTestManager(t)
+	httpDir := m.config.Global.NginxConfigDir
+	streamDir := m.config.Global.StreamConfigDir
+
+	for _, service := range []*config.ServiceConfig{
+		httpService("api", "a.example.com", "/"),
+		httpService("web", "a.example.com", "/web"),
+		httpService("old", "old.example.com", "/"),
+		streamService("db", 3306),
+		streamService("cache", 6379),
+	} {
+		if err := register(t, m, service, service.Name, "10.0.0.1"); err != nil {
+			t.Fatalf("注册服务 %s 失败: %v", service.Name, err)
+		}
+	}
+	// 程序重启前生成的配置文件，内存中没有对应的服务
+	writeFile(t, filepath.Join(httpDir, "gone.example.com.conf"), managedHeader("domain", "gone.example.com", "")+"server {}\n")
+	writeFile(t, filepath.Join(streamDir, "gone.conf"), managedHeader("service", "gone", "")+"server {}\n")
+	// 不是由本程序生成的文件
+	writeFile(t, filepath.Join(httpDir, "manual.conf"), "server {}\n")
+	writeFile(t, filepath.Join(streamDir, "manual.conf"), "server {}\n")
+
+	removed, err := m.CleanupOrphans(map[string]string{"api": "http", "db": "stream"})
+	if err != nil {
+		t.Fatalf("CleanupOrphans() 错误: %v", err)
+	}
+	slices.Sort(removed)
+	want := []string{"a.example.com", "cache", "gone", "gone.example.com", "old.example.com"}
+	if !slices.Equal(removed, want) {
+		t.Errorf("removed = %v, 期望 %v", removed, want)
+	}
+
+	tests := []struct {
+		path   string
+		exists bool
+	}{
+		{filepath.Join(httpDir, "a.example.com.conf"), true},
+		{filepath.Join(httpDir, "old.example.com.conf"), false},
+		{filepath.Join(httpDir, "gone.example.com.conf"), false},
+		{filepath.Join(httpDir, "manual.conf"), true},
+		{filepath.Join(streamDir, "db.conf"), true},
+		{filepath.Join(streamDir, "cache.conf"), false},
+		{filepath.Join(streamDir, "gone.conf"), false},
+		{filepath.Join(streamDir, "manual.conf"), true},
+	}
+	for _, tt := range tests {
+		if _, err := os.Stat(tt.path); (err == nil) != tt.exists {
+			t.Errorf("%s 存在 = %v, 期望 %v", tt.path, err == nil, tt.exists)
+		}
+	}
+
+	// 域名下还有其他服务时重新生成配置，只移除被删除的服务
+	content := readConfig(t, filepath.Join(httpDir, "a.example.com.conf"))
+	if !strings.Contains(content, "api_backend") || strings.Contains(content, "web_backend") {
+		t.Errorf("a.example.com.conf 未重新生成:\n%s", content)
+	}
+	for _, name := range []string{"web", "old", "cache"} {
+		if m.HasService(name) {
+			t.Errorf("服务 %s 未从内存中移除", name)
+		}
+		if _, registered := m.GetContainer(name); registered {
+			t.Errorf("服务 %s 的容器注册信息未移除", name)
+		}
+	}
+}
+
+func TestLegacyStreamFile(t *testing.T) {
+	tests := []struct {
+		name string
+		// 配置文件中的Stream服务
+		configured []string
+		// 旧版本生成的没有标记的文件
+		file    string
+		service string
+		wantErr bool
+	}{
+		{"接管配置文件中的服务的旧文件", []string{"db"}, "db.conf", "db", false},
+		{"不接管容器标签声明的服务的同名文件", nil, "db.conf", "db", true},
+		{"不接管其他服务的文件", []string{"db"}, "cache.conf", "cache", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t)
+			for _, name := range tt.configured {
+				m.config.Services = append(m.config.Services, *streamService(name, 3306))
+			}
+			path := filepath.Join(m.config.Global.StreamConfigDir, tt.file)
+			writeFile(t, path, "upstream legacy {}\n")
+
+			err := register(t, m, streamService(tt.service, 3306), "c1", "10.0.0.1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UpdateService() 错误 = %v, 期望错误 %v", err, tt.wantErr)
+			}
+			content := readConfig(t, path)
+			if tt.wantErr {
+				if content != "upstream legacy {}\n" {
+					t.Errorf("没有标记的文件被修改:\n%s", content)
+				}
+				return
+			}
+			if key, name, managed := contentOwner([]byte(content)); !managed || key != "service" || name != tt.service {
+				t.Errorf("接管后的文件没有标记:\n%s", content)
+			}
+		})
+	}
+}
+
+func TestRemoveLegacyStreamFile(t *testing.T) {
+	m := newTestManager(t)
+	m.config.Services = []config.ServiceConfig{*streamService("db", 3306)}
+	path := filepath.Join(m.config.Global.StreamConfigDir, "db.conf")
+	writeFile(t, path, "upstream legacy {}\n")
+
+	// 服务没有上游服务器时删除旧版本生成的文件
+	if err := m.UpdateService(streamService("db", 3306), "", nil, "", config.ServerOptions{}); err != nil {
+		t.Fatalf("UpdateService() 错误: %v", err)
+	}
+	assertNotExist(t, path)
+}
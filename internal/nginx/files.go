@@ -0,0 +1,187 @@
+package nginx
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileBackup 配置文件修改前的内容，用于nginx配置测试失败时回滚
+type fileBackup struct {
+	existed bool
+	content []byte
+}
+
+// backupFile 在第一次修改配置文件前记录其原始内容
+func (m *Manager) backupFile(path string) error {
+	if _, exists := m.backups[path]; exists {
+		return nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			m.backups[path] = &fileBackup{existed: false}
+			return nil
+		}
+		return fmt.Errorf("备份配置文件失败 [%s]: %w", path, err)
+	}
+
+	m.backups[path] = &fileBackup{existed: true, content: content}
+	return nil
+}
+
+// checkManaged 检查已存在的配置文件是否由本程序生成，不会覆盖或删除其他来源的文件（如手写的同名站点配置）
+// 旧版本为配置文件中的Stream服务生成的 <服务名称>.conf 没有标记，升级后由本程序接管
+func (m *Manager) checkManaged(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if _, _, managed := readManagedOwner(path); managed {
+		return nil
+	}
+	if m.isLegacyStreamFile(path) {
+		log.Printf("接管旧版本生成的Stream配置文件: %s", path)
+		return nil
+	}
+	return fmt.Errorf("配置文件 %s 已存在且不是由docker-tool生成的，请重命名或删除该文件", path)
+}
+
+// isLegacyStreamFile 配置文件是否为旧版本为配置文件中的Stream服务生成的配置文件
+// 旧版本与本程序使用相同的文件名，Stream配置目录中与配置文件中的Stream服务同名的文件视为旧版本生成的
+func (m *Manager) isLegacyStreamFile(path string) bool {
+	if filepath.Dir(path) != filepath.Clean(m.config.Global.StreamConfigDir) {
+		return false
+	}
+	serviceName := strings.TrimSuffix(filepath.Base(path), ".conf")
+	for _, service := range m.config.Services {
+		if service.Type == "stream" && service.Name == serviceName {
+			return true
+		}
+	}
+	return false
+}
+
+// writeConfigFile 写入配置文件，写入前备份原始内容，内容没有变化时不写入
+func (m *Manager) writeConfigFile(path string, content []byte) error {
+	if err := m.checkManaged(path); err != nil {
+		return err
+	}
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, content) {
+		return nil
+	}
+	if err := m.backupFile(path); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, content)
+}
+
+// writeFileAtomic 先写入同目录下的临时文件再重命名，避免nginx读到不完整的配置文件
+// 临时文件不以 .conf 结尾，不会被nginx的 include *.conf 加载
+func writeFileAtomic(path string, content []byte) error {
+	dir := filepath.Dir(path)
+	tmpFile, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+
+	if _, err := tmpFile.Write(content); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// removeConfigFile 删除配置文件，删除前备份原始内容
+func (m *Manager) removeConfigFile(path string) error {
+	if err := m.checkManaged(path); err != nil {
+		return err
+	}
+	if err := m.backupFile(path); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// restoreFile 将配置文件恢复为备份的内容，备份时文件不存在则删除
+func restoreFile(path string, backup *fileBackup) error {
+	if backup.existed {
+		return writeFileAtomic(path, backup.content)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// restoreBackups 将所有修改过的配置文件恢复为修改前的内容
+func (m *Manager) restoreBackups() error {
+	var firstErr error
+	for path, backup := range m.backups {
+		if err := restoreFile(path, backup); err != nil {
+			log.Printf("警告: 恢复配置文件失败 [%s]: %v", path, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		log.Printf("已恢复配置文件: %s", path)
+	}
+
+	m.backups = make(map[string]*fileBackup)
+	return firstErr
+}
+
+// commitBackups 配置测试通过后丢弃备份
+func (m *Manager) commitBackups() {
+	m.backups = make(map[string]*fileBackup)
+}
+
+// finishUpdate 完成一次更新，测试本次更新修改过的配置文件，测试失败或更新出错时恢复这些文件和更新前的注册信息
+// 一次更新修改的所有配置文件（如服务换域名时新旧两个域名的配置）一起生效或一起恢复，未通过测试的配置不会留在配置目录中等待重载
+func (m *Manager) finishUpdate(before managerState, err error) error {
+	if err == nil && len(m.backups) > 0 {
+		if testErr := m.testConfig(); testErr != nil {
+			err = fmt.Errorf("nginx配置测试失败，已恢复修改前的配置文件: %w", testErr)
+		}
+	}
+	if err != nil {
+		m.restoreState(before)
+		if restoreErr := m.restoreBackups(); restoreErr != nil {
+			log.Printf("警告: 恢复配置文件失败: %v", restoreErr)
+		}
+		return err
+	}
+
+	m.commitBackups()
+	return nil
+}
@@ -2,83 +2,217 @@ package nginx
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"net"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"text/template"
+	"time"
 
+	"github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
 
 	"docker-tool/internal/config"
 )
 
+// Docker内置DNS，用户自定义网络中的容器可以通过它解析其他容器的名称
+const defaultResolver = "127.0.0.11 valid=10s"
+
+// UDP代理默认的超时时间
+const defaultUDPProxyTimeout = 10 * time.Second
+
 // Manager nginx配置管理器
 type Manager struct {
 	config        *config.Config
 	httpConfigs   map[string]*HTTPConfig
 	streamConfigs map[string]*StreamConfig
-	mutex         sync.RWMutex
+	// 容器ID到其贡献的上游服务器的映射
+	containers map[string]*ContainerEntry
+	// 本次更新修改过的配置文件的原始内容
+	backups map[string]*fileBackup
+	// 已记录过跳过检查的证书文件，避免每次更新服务时重复记录
+	skippedCerts map[string]bool
+	// 用于通过Docker API重载nginx
+	dockerClient *client.Client
+	mutex        sync.RWMutex
+}
+
+// ContainerEntry 记录某个容器注册到哪个服务的哪个上游服务器
+type ContainerEntry struct {
+	ContainerID string
+	ServiceName string
+	ServiceType string
+	IPs         []string
+	Port        nat.Port
+	Options     config.ServerOptions
+	// 上游服务器已被标记为 down
+	Down bool
+}
+
+// Addresses 获取容器贡献的上游服务器地址，用于日志
+func (e ContainerEntry) Addresses() string {
+	addresses := make([]string, 0, len(e.IPs))
+	for _, ip := range e.IPs {
+		addresses = append(addresses, net.JoinHostPort(ip, e.Port.Port()))
+	}
+	return strings.Join(addresses, ", ")
 }
 
 // HTTPConfig HTTP服务配置
 type HTTPConfig struct {
-	ServiceName string
-	Domain      string
-	Path        string
-	Upstream    []UpstreamServer
-	ProxyConfig *config.ProxyConfig
+	ServiceName    string
+	UpstreamName   string
+	Domain         string
+	Path           string
+	Upstream       []UpstreamServer
+	ProxyConfig    *config.ProxyConfig
+	UpstreamConfig *config.UpstreamConfig
+	// 各轨道的流量百分比
+	Tracks map[string]int
+	// 域名别名
+	Domains []string
+	// 生效的TLS配置
+	TLS config.TLSSettings
+	// 没有上游服务器时的处理方式和维护页面
+	OnEmpty     string
+	Maintenance config.MaintenanceConfig
+	// 最后一次非空的上游服务器列表，on_empty 为 keep 时使用
+	lastUpstream []UpstreamServer
 }
 
 // StreamConfig Stream服务配置
 type StreamConfig struct {
-	ServiceName     string
-	ListenPort      int
-	Upstream        []UpstreamServer
+	ServiceName  string
+	UpstreamName string
+	ListenPort   int
+	Upstream     []UpstreamServer
 	// SNI 路由相关字段
 	EnableSNI       bool
-	DomainRoutes    map[string]string     // 域名到upstream的映射
-	StaticUpstreams map[string][]string   // 静态upstream配置
+	DomainRoutes    map[string]string   // 域名到upstream的映射
+	StaticUpstreams map[string][]string // 静态upstream配置
+	// 协议: tcp、udp、both
+	Protocol       string
+	ProxyResponses *int
+	ProxyTimeout   time.Duration
+	// 负载均衡配置
+	UpstreamConfig *config.UpstreamConfig
+	// 各轨道的流量百分比
+	Tracks map[string]int
 }
 
 // UpstreamServer 上游服务器
 type UpstreamServer struct {
-	IP   string
-	Port nat.Port
+	ContainerID string
+	// IP地址，dns 地址模式下为容器名称
+	IP      string
+	Port    nat.Port
+	Options config.ServerOptions
+	// 正在摘除流量，不再接收新的请求
+	Down bool
+}
+
+// IsHostname 上游服务器是否使用容器名称而不是IP地址
+func (s UpstreamServer) IsHostname() bool {
+	return net.ParseIP(s.IP) == nil
+}
+
+// Params 获取上游服务器参数，如 " weight=3 max_fails=2 fail_timeout=10s"，没有参数时为空字符串
+func (s UpstreamServer) Params() string {
+	var params []string
+	if s.Options.Weight > 0 {
+		params = append(params, fmt.Sprintf("weight=%d", s.Options.Weight))
+	}
+	if s.Options.MaxFails != nil {
+		params = append(params, fmt.Sprintf("max_fails=%d", *s.Options.MaxFails))
+	}
+	if s.Options.FailTimeout > 0 {
+		params = append(params, "fail_timeout="+nginxDuration(s.Options.FailTimeout))
+	}
+	if s.Options.Backup {
+		params = append(params, "backup")
+	}
+	if s.Down {
+		params = append(params, "down")
+	}
+	if len(params) == 0 {
+		return ""
+	}
+	return " " + strings.Join(params, " ")
+}
+
+// Address 获取上游服务器地址，IPv6地址会加上方括号
+func (s UpstreamServer) Address() string {
+	return net.JoinHostPort(s.IP, s.Port.Port())
 }
 
-// HTTPTemplateData HTTP配置模板数据
+// HTTPTemplateData HTTP配置模板数据，同一域名的所有服务生成在一个server块中
 type HTTPTemplateData struct {
-	ServiceName          string
-	Domain               string
-	Path                 string
-	Upstream             []UpstreamServer
-	EnableWebSocket      bool
-	ClientMaxBodySize    string
-	ProxyHTTPVersion     string
-	ProxyHeaders         []string
-	ProxyRedirect        string
+	Domain string
+	// server_name，包括域名别名
+	ServerName string
+	Locations  []HTTPLocation
+	// 任意一个location启用了WebSocket
+	EnableWebSocket bool
 	// SSL 相关配置
-	EnableSSL            bool
-	SSLCertificate       string
-	SSLCertificateKey    string
-	ForceHTTPS           bool
+	EnableSSL         bool
+	SSLCertificate    string
+	SSLCertificateKey string
+	ForceHTTPS        bool
+	HSTS              bool
+	// 上游服务器使用容器名称时的DNS解析服务器
+	Resolver string
+}
+
+// HTTPLocation 域名下一个服务的location和upstream
+type HTTPLocation struct {
+	ServiceName  string
+	UpstreamName string
+	Path         string
+	Upstream     []UpstreamServer
+	// 负载均衡指令，如 least_conn、hash $request_uri consistent
+	Balance           string
+	Keepalive         int
+	EnableWebSocket   bool
+	ClientMaxBodySize string
+	ProxyHTTPVersion  string
+	ProxyHeaders      []string
+	ProxyRedirect     string
+	// 服务没有上游服务器，返回503维护页面或重定向
+	Maintenance         bool
+	MaintenanceRoot     string
+	MaintenanceFile     string
+	MaintenanceRedirect string
 }
 
 // StreamTemplateData Stream配置模板数据
 type StreamTemplateData struct {
-	ServiceName   string
-	ListenPort    int
-	Upstream      []UpstreamServer
+	ServiceName  string
+	UpstreamName string
+	ListenPort   int
+	Upstream     []UpstreamServer
 	// SNI 路由相关字段
-	EnableSNI     bool
-	DomainRoutes  map[string]string       // 域名到upstream的映射
-	DefaultRoute  string                  // 默认路由
-	StaticUpstreams map[string][]string   // 静态upstream配置
+	EnableSNI       bool
+	DomainRoutes    map[string]string   // 域名到upstream的映射
+	DefaultRoute    string              // 默认路由
+	StaticUpstreams map[string][]string // 静态upstream配置
+	Resolver        string              // 上游服务器使用容器名称时的DNS解析服务器
+	Balance         string              // 负载均衡指令
+	// 监听的协议
+	ListenTCP bool
+	ListenUDP bool
+	// UDP代理配置
+	ProxyResponses string
+	ProxyTimeout   string
 }
 
 // loadTemplate 从文件加载模板内容
@@ -109,55 +243,328 @@ func (m *Manager) loadTemplate(templateFile string) (string, error) {
 
 
 // NewManager 创建nginx管理器
-func NewManager(cfg *config.Config) *Manager {
+func NewManager(cfg *config.Config, dockerClient *client.Client) *Manager {
 	return &Manager{
 		config:        cfg,
+		dockerClient:  dockerClient,
 		httpConfigs:   make(map[string]*HTTPConfig),
 		streamConfigs: make(map[string]*StreamConfig),
+		containers:    make(map[string]*ContainerEntry),
+		backups:       make(map[string]*fileBackup),
+		skippedCerts:  make(map[string]bool),
+	}
+}
+
+// UpdateService 更新服务配置，将容器注册为服务的上游服务器
+// containerIPs 为容器的地址（双栈时包含IPv4和IPv6地址），每个地址作为一个上游服务器
+// containerID 为空时只生成服务配置（如不依赖容器的SNI服务）
+// 配置了测试命令时写入后立即测试，测试失败时恢复本次修改的配置文件和注册信息并返回错误
+func (m *Manager) UpdateService(service *config.ServiceConfig, containerID string, containerIPs []string, containerPort nat.Port, options config.ServerOptions) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	before := m.copyState()
+	return m.finishUpdate(before, m.updateService(service, containerID, containerIPs, containerPort, options))
+}
+
+// updateService 将容器注册为服务的上游服务器并生成配置文件
+func (m *Manager) updateService(service *config.ServiceConfig, containerID string, containerIPs []string, containerPort nat.Port, options config.ServerOptions) error {
+	if containerID != "" {
+		// 容器之前注册在其他服务下（如重命名后匹配到新服务），先从旧服务中移除
+		if entry, exists := m.containers[containerID]; exists && entry.ServiceName != service.Name {
+			if err := m.removeContainer(entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	if service.Type != "http" && service.Type != "stream" {
+		return fmt.Errorf("不支持的服务类型: %s", service.Type)
+	}
+	if err := m.checkUpstreamName(service); err != nil {
+		return err
+	}
+	if service.Type == "http" {
+		if err := m.verifyCertificate(service); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if service.Type == "http" {
+		err = m.updateHTTPService(service, containerID, containerIPs, containerPort, options)
+	} else {
+		err = m.updateStreamService(service, containerID, containerIPs, containerPort, options)
+	}
+	if err != nil {
+		return err
+	}
+
+	// 配置文件写入成功后才登记容器，写入失败时定期同步会发现容器未注册并重试
+	if containerID != "" && len(containerIPs) > 0 && containerPort != "" {
+		m.containers[containerID] = &ContainerEntry{
+			ContainerID: containerID,
+			ServiceName: service.Name,
+			ServiceType: service.Type,
+			IPs:         containerIPs,
+			Port:        containerPort,
+			Options:     options,
+		}
+	}
+	return nil
+}
+
+// RemoveContainer 移除容器贡献的上游服务器
+// 返回容器所属的服务名称，容器未注册且不在任何服务的上游服务器列表中时返回空字符串
+func (m *Manager) RemoveContainer(containerID string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry, exists := m.containers[containerID]
+	if !exists {
+		entry, exists = m.upstreamEntry(containerID)
+	}
+	if !exists {
+		return "", nil
 	}
+
+	before := m.copyState()
+	return entry.ServiceName, m.finishUpdate(before, m.removeContainer(entry))
 }
 
-// UpdateService 更新服务配置
-func (m *Manager) UpdateService(service *config.ServiceConfig, containerIP string, containerPort nat.Port) error {
+// DrainContainer 将容器的上游服务器标记为 down 并重新生成配置，nginx重载后不再向其转发新的请求
+// 返回容器所属的服务名称，容器未注册时返回空字符串
+func (m *Manager) DrainContainer(containerID string) (string, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	switch service.Type {
+	entry, exists := m.containers[containerID]
+	if !exists {
+		return "", nil
+	}
+
+	before := m.copyState()
+	return entry.ServiceName, m.finishUpdate(before, m.drainContainer(entry))
+}
+
+// drainContainer 将容器的上游服务器标记为 down 并重新生成配置
+func (m *Manager) drainContainer(entry *ContainerEntry) error {
+	entry.Down = true
+	containerID := entry.ContainerID
+
+	switch entry.ServiceType {
 	case "http":
-		return m.updateHTTPService(service, containerIP, containerPort)
+		httpConfig, exists := m.httpConfigs[entry.ServiceName]
+		if !exists {
+			return nil
+		}
+		markServersDown(httpConfig.Upstream, containerID)
+		return m.generateHTTPConfig(httpConfig)
 	case "stream":
-		return m.updateStreamService(service, containerIP, containerPort)
+		streamConfig, exists := m.streamConfigs[entry.ServiceName]
+		if !exists {
+			return nil
+		}
+		markServersDown(streamConfig.Upstream, containerID)
+		return m.generateStreamConfig(streamConfig)
 	default:
-		return fmt.Errorf("不支持的服务类型: %s", service.Type)
+		return fmt.Errorf("不支持的服务类型: %s", entry.ServiceType)
+	}
+}
+
+// upstreamEntry 在服务的上游服务器列表中查找没有注册信息的容器
+func (m *Manager) upstreamEntry(containerID string) (*ContainerEntry, bool) {
+	for serviceName, httpConfig := range m.httpConfigs {
+		if hasContainer(httpConfig.Upstream, containerID) {
+			return &ContainerEntry{ContainerID: containerID, ServiceName: serviceName, ServiceType: "http"}, true
+		}
+	}
+	for serviceName, streamConfig := range m.streamConfigs {
+		if hasContainer(streamConfig.Upstream, containerID) {
+			return &ContainerEntry{ContainerID: containerID, ServiceName: serviceName, ServiceType: "stream"}, true
+		}
+	}
+	return nil, false
+}
+
+// hasContainer 上游服务器列表中是否有容器的服务器
+func hasContainer(upstream []UpstreamServer, containerID string) bool {
+	for _, server := range upstream {
+		if server.ContainerID == containerID {
+			return true
+		}
+	}
+	return false
+}
+
+// GetContainer 获取容器的注册信息
+func (m *Manager) GetContainer(containerID string) (ContainerEntry, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	entry, exists := m.containers[containerID]
+	if !exists {
+		return ContainerEntry{}, false
+	}
+	return *entry, true
+}
+
+// HasService 服务是否已经生成了配置
+func (m *Manager) HasService(serviceName string) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	_, isHTTP := m.httpConfigs[serviceName]
+	_, isStream := m.streamConfigs[serviceName]
+	return isHTTP || isStream
+}
+
+// Containers 获取所有已注册容器的快照
+func (m *Manager) Containers() map[string]ContainerEntry {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	containers := make(map[string]ContainerEntry, len(m.containers))
+	for containerID, entry := range m.containers {
+		containers[containerID] = *entry
+	}
+	return containers
+}
+
+// checkUpstreamName 检查服务的upstream名称是否与其他服务生成的upstream冲突，HTTP服务还检查域名和路径
+// HTTP和Stream的upstream在nginx的不同上下文中，只检查同类型的服务
+func (m *Manager) checkUpstreamName(service *config.ServiceConfig) error {
+	if service.Type == "http" {
+		for name, other := range m.httpConfigs {
+			if name == service.Name {
+				continue
+			}
+			if other.UpstreamName == service.UpstreamName {
+				return fmt.Errorf("服务 %s 的upstream名称 %s 已被服务 %s 使用", service.Name, service.UpstreamName, name)
+			}
+			// 同一域名下的服务生成在一个server块中，路径不能重复，TLS配置必须相同
+			if other.Domain == service.Domain && other.Path == service.Path {
+				return fmt.Errorf("服务 %s 的 %s%s 已被服务 %s 使用", service.Name, service.Domain, service.Path, name)
+			}
+			if other.Domain == service.Domain && other.TLS != m.config.TLS(service) {
+				return fmt.Errorf("服务 %s 与服务 %s 使用同一域名 %s，TLS配置必须相同", service.Name, name, service.Domain)
+			}
+			if other.Domain != service.Domain {
+				for _, serverName := range service.ServerNames() {
+					if serverName == other.Domain || slices.Contains(other.Domains, serverName) {
+						return fmt.Errorf("服务 %s 的域名 %s 已被服务 %s 使用", service.Name, serverName, name)
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	names := config.UpstreamNames(service)
+	for name, other := range m.streamConfigs {
+		if name == service.Name {
+			continue
+		}
+		for _, upstreamName := range names {
+			_, isStatic := other.StaticUpstreams[upstreamName]
+			if other.UpstreamName == upstreamName || isStatic {
+				return fmt.Errorf("服务 %s 的upstream名称 %s 已被服务 %s 使用", service.Name, upstreamName, name)
+			}
+		}
+	}
+	return nil
+}
+
+// verifyCertificate 检查服务生效的证书是否包含服务的所有域名，包括容器标签声明的域名别名
+// 证书文件不存在时（如路径为nginx容器内的路径）跳过检查
+func (m *Manager) verifyCertificate(service *config.ServiceConfig) error {
+	tls := m.config.TLS(service)
+	if !tls.Enabled() {
+		return nil
+	}
+	err := config.VerifyCertificate(tls.Certificate, service.ServerNames())
+	if errors.Is(err, fs.ErrNotExist) {
+		if !m.skippedCerts[tls.Certificate] {
+			m.skippedCerts[tls.Certificate] = true
+			log.Printf("警告: 证书文件 %s 不存在，跳过证书域名检查 [服务: %s]", tls.Certificate, service.Name)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("服务 %s 的证书无效: %w", service.Name, err)
+	}
+	return nil
+}
+
+// removeContainer 从服务的上游服务器列表中移除容器并重新生成配置
+func (m *Manager) removeContainer(entry *ContainerEntry) error {
+	delete(m.containers, entry.ContainerID)
+
+	switch entry.ServiceType {
+	case "http":
+		httpConfig, exists := m.httpConfigs[entry.ServiceName]
+		if !exists {
+			return nil
+		}
+		m.removeUpstreamServer(&httpConfig.Upstream, entry.ContainerID)
+		return m.generateHTTPConfig(httpConfig)
+	case "stream":
+		streamConfig, exists := m.streamConfigs[entry.ServiceName]
+		if !exists {
+			return nil
+		}
+		m.removeUpstreamServer(&streamConfig.Upstream, entry.ContainerID)
+		return m.generateStreamConfig(streamConfig)
+	default:
+		return fmt.Errorf("不支持的服务类型: %s", entry.ServiceType)
 	}
 }
 
 // updateHTTPService 更新HTTP服务配置
-func (m *Manager) updateHTTPService(service *config.ServiceConfig, containerIP string, containerPort nat.Port) error {
+func (m *Manager) updateHTTPService(service *config.ServiceConfig, containerID string, containerIPs []string, containerPort nat.Port, options config.ServerOptions) error {
 	// 获取或创建HTTP配置
 	httpConfig, exists := m.httpConfigs[service.Name]
 	if !exists {
 		httpConfig = &HTTPConfig{
-			ServiceName: service.Name,
-			Domain:      service.Domain,
-			Path:        service.Path,
-			Upstream:    make([]UpstreamServer, 0),
-			ProxyConfig: service.ProxyConfig,
+			ServiceName:    service.Name,
+			UpstreamName:   service.UpstreamName,
+			Domain:         service.Domain,
+			Path:           service.Path,
+			Upstream:       make([]UpstreamServer, 0),
+			ProxyConfig:    service.ProxyConfig,
+			UpstreamConfig: service.Upstream,
+			Tracks:         service.Tracks,
+			Domains:        service.Domains,
+			TLS:            m.config.TLS(service),
+			OnEmpty:        m.config.OnEmpty(service),
+			Maintenance:    m.config.Maintenance(service),
 		}
 		m.httpConfigs[service.Name] = httpConfig
 	}
+	// 配置文件重新加载后服务配置可能已变化
+	previousDomain := httpConfig.Domain
+	httpConfig.UpstreamName = service.UpstreamName
+	httpConfig.Domain = service.Domain
+	httpConfig.Path = service.Path
+	httpConfig.ProxyConfig = service.ProxyConfig
+	httpConfig.UpstreamConfig = service.Upstream
+	httpConfig.Tracks = service.Tracks
+	httpConfig.Domains = service.Domains
+	httpConfig.TLS = m.config.TLS(service)
+	httpConfig.OnEmpty = m.config.OnEmpty(service)
+	httpConfig.Maintenance = m.config.Maintenance(service)
 
 	// 更新上游服务器列表
-	if containerIP != "" && containerPort != "" {
-		// 添加或更新服务器
-		server := UpstreamServer{
-			IP:   containerIP,
-			Port: containerPort,
+	if containerID != "" && len(containerIPs) > 0 && containerPort != "" {
+		// 添加或更新容器的服务器
+		m.updateUpstreamServers(&httpConfig.Upstream, containerID, newUpstreamServers(containerID, containerIPs, containerPort, options))
+	}
+
+	// 服务换了域名时，从原域名的配置中移除
+	if previousDomain != httpConfig.Domain {
+		if err := m.generateHTTPDomain(previousDomain); err != nil {
+			return err
 		}
-		m.updateUpstreamServer(&httpConfig.Upstream, server)
-	} else {
-		// 移除服务器
-		m.removeUpstreamServer(&httpConfig.Upstream, containerIP)
 	}
 
 	// 生成配置文件
@@ -165,165 +572,342 @@ func (m *Manager) updateHTTPService(service *config.ServiceConfig, containerIP s
 }
 
 // updateStreamService 更新Stream服务配置
-func (m *Manager) updateStreamService(service *config.ServiceConfig, containerIP string, containerPort nat.Port) error {
+func (m *Manager) updateStreamService(service *config.ServiceConfig, containerID string, containerIPs []string, containerPort nat.Port, options config.ServerOptions) error {
 	// 获取或创建Stream配置
 	streamConfig, exists := m.streamConfigs[service.Name]
 	if !exists {
 		streamConfig = &StreamConfig{
 			ServiceName:     service.Name,
+			UpstreamName:    service.UpstreamName,
 			ListenPort:      service.ListenPort,
 			Upstream:        make([]UpstreamServer, 0),
 			EnableSNI:       service.EnableSNI,
 			DomainRoutes:    service.DomainRoutes,
 			StaticUpstreams: service.StaticUpstreams,
+			Protocol:        service.StreamProtocol(),
+			ProxyResponses:  service.ProxyResponses,
+			ProxyTimeout:    service.ProxyTimeout,
+			UpstreamConfig:  service.Upstream,
+			Tracks:          service.Tracks,
 		}
 		m.streamConfigs[service.Name] = streamConfig
 	}
+	// 配置文件重新加载后服务配置可能已变化
+	streamConfig.UpstreamName = service.UpstreamName
+	streamConfig.ListenPort = service.ListenPort
+	streamConfig.EnableSNI = service.EnableSNI
+	streamConfig.DomainRoutes = service.DomainRoutes
+	streamConfig.StaticUpstreams = service.StaticUpstreams
+	streamConfig.Protocol = service.StreamProtocol()
+	streamConfig.ProxyResponses = service.ProxyResponses
+	streamConfig.ProxyTimeout = service.ProxyTimeout
+	streamConfig.UpstreamConfig = service.Upstream
+	streamConfig.Tracks = service.Tracks
 
 	// 更新上游服务器列表
-	if containerIP != "" && containerPort != "" {
-		// 添加或更新服务器
-		server := UpstreamServer{
-			IP:   containerIP,
-			Port: containerPort,
-		}
-		m.updateUpstreamServer(&streamConfig.Upstream, server)
-	} else {
-		// 移除服务器
-		m.removeUpstreamServer(&streamConfig.Upstream, containerIP)
+	if containerID != "" && len(containerIPs) > 0 && containerPort != "" {
+		// 添加或更新容器的服务器
+		m.updateUpstreamServers(&streamConfig.Upstream, containerID, newUpstreamServers(containerID, containerIPs, containerPort, options))
 	}
 
 	// 生成配置文件
 	return m.generateStreamConfig(streamConfig)
 }
 
-// updateUpstreamServer 更新上游服务器
-func (m *Manager) updateUpstreamServer(upstream *[]UpstreamServer, server UpstreamServer) {
-	// 查找是否已存在相同IP的服务器
-	for i, existingServer := range *upstream {
-		if existingServer.IP == server.IP {
-			(*upstream)[i] = server
-			return
+// newUpstreamServers 为容器的每个地址创建上游服务器
+func newUpstreamServers(containerID string, containerIPs []string, containerPort nat.Port, options config.ServerOptions) []UpstreamServer {
+	servers := make([]UpstreamServer, 0, len(containerIPs))
+	for _, ip := range containerIPs {
+		servers = append(servers, UpstreamServer{
+			ContainerID: containerID,
+			IP:          ip,
+			Port:        containerPort,
+			Options:     options,
+		})
+	}
+	return servers
+}
+
+// updateUpstreamServers 用新的服务器替换容器原有的上游服务器，保持其在列表中的位置
+func (m *Manager) updateUpstreamServers(upstream *[]UpstreamServer, containerID string, servers []UpstreamServer) {
+	position := -1
+	remaining := make([]UpstreamServer, 0, len(*upstream)+len(servers))
+	for _, existingServer := range *upstream {
+		if existingServer.ContainerID == containerID {
+			if position < 0 {
+				position = len(remaining)
+			}
+			continue
+		}
+		remaining = append(remaining, existingServer)
+	}
+
+	// 如果不存在，添加到末尾
+	if position < 0 {
+		position = len(remaining)
+	}
+	updated := append([]UpstreamServer{}, remaining[:position]...)
+	updated = append(updated, servers...)
+	updated = append(updated, remaining[position:]...)
+	*upstream = updated
+}
+
+// markServersDown 将容器的上游服务器标记为 down
+func markServersDown(upstream []UpstreamServer, containerID string) {
+	for i := range upstream {
+		if upstream[i].ContainerID == containerID {
+			upstream[i].Down = true
 		}
 	}
-	// 如果不存在，添加新服务器
-	*upstream = append(*upstream, server)
 }
 
-// removeUpstreamServer 移除上游服务器
-func (m *Manager) removeUpstreamServer(upstream *[]UpstreamServer, ip string) {
-	for i, server := range *upstream {
-		if server.IP == ip {
-			*upstream = append((*upstream)[:i], (*upstream)[i+1:]...)
-			return
+// liveServers 获取可以写入配置的上游服务器
+// 开源版nginx只在加载配置时解析upstream中的域名，无法解析时配置测试失败，
+// 正在摘除流量的容器的名称可能已从Docker DNS中移除，因此 dns 地址模式下直接去掉这些服务器而不是标记为 down
+func liveServers(upstream []UpstreamServer) []UpstreamServer {
+	servers := make([]UpstreamServer, 0, len(upstream))
+	for _, server := range upstream {
+		if server.Down && server.IsHostname() {
+			continue
 		}
+		servers = append(servers, server)
 	}
+	return servers
+}
+
+// removeUpstreamServer 移除容器对应的所有上游服务器
+func (m *Manager) removeUpstreamServer(upstream *[]UpstreamServer, containerID string) {
+	m.updateUpstreamServers(upstream, containerID, nil)
 }
 
-// generateHTTPConfig 生成HTTP配置文件
+// generateHTTPConfig 重新生成服务所在域名的HTTP配置文件
+// 服务没有上游服务器时按 on_empty 处理，delete 时从域名中移除该服务的location
 func (m *Manager) generateHTTPConfig(httpConfig *HTTPConfig) error {
-	if len(httpConfig.Upstream) == 0 {
-		// 如果没有上游服务器，删除配置文件
-		return m.deleteHTTPConfig(httpConfig.ServiceName)
+	if len(httpConfig.Upstream) > 0 {
+		httpConfig.lastUpstream = append([]UpstreamServer(nil), httpConfig.Upstream...)
+	} else if httpConfig.OnEmpty != config.OnEmptyMaintenance && len(httpConfig.emptyUpstream()) == 0 {
+		delete(m.httpConfigs, httpConfig.ServiceName)
 	}
+	return m.generateHTTPDomain(httpConfig.Domain)
+}
 
-	// 生成配置内容
-	configContent := m.buildHTTPConfigContent(httpConfig)
+// servers 获取生成配置时使用的上游服务器，没有上游服务器时按 on_empty 处理
+func (c *HTTPConfig) servers() []UpstreamServer {
+	if servers := liveServers(c.Upstream); len(servers) > 0 {
+		return servers
+	}
+	return c.emptyUpstream()
+}
+
+// emptyUpstream 获取服务没有上游服务器时使用的上游服务器，只有 on_empty 为 keep 时不为空
+func (c *HTTPConfig) emptyUpstream() []UpstreamServer {
+	if c.OnEmpty != config.OnEmptyKeep {
+		return nil
+	}
+	return c.lastUpstream
+}
+
+// generateHTTPDomain 生成域名的HTTP配置文件，包含该域名下所有服务的location
+func (m *Manager) generateHTTPDomain(domain string) error {
+	httpConfigs := m.domainConfigs(domain)
+	if len(httpConfigs) == 0 {
+		// 如果域名下没有服务，删除配置文件
+		return m.deleteHTTPConfig(domain)
+	}
+
+	// 生成配置内容，渲染失败时不写入文件
+	configContent, err := m.buildHTTPConfigContent(domain, httpConfigs)
+	if err != nil {
+		return err
+	}
+	configContent = managedHeader("domain", domain, configContent) + configContent
 
 	// 写入配置文件
-	filename := fmt.Sprintf("%s.conf", httpConfig.ServiceName)
+	filename := domainFileName(domain)
 	filepath := filepath.Join(m.config.Global.NginxConfigDir, filename)
 
-	if err := os.WriteFile(filepath, []byte(configContent), 0644); err != nil {
+	if err := m.writeConfigFile(filepath, []byte(configContent)); err != nil {
 		return fmt.Errorf("写入HTTP配置文件失败 [%s]: %w", filename, err)
 	}
 
 	return nil
 }
 
+// domainConfigs 获取域名下的所有服务，按路径排序
+// 没有上游服务器的服务只有 on_empty 为 maintenance 或 keep 时才会保留
+func (m *Manager) domainConfigs(domain string) []*HTTPConfig {
+	var httpConfigs []*HTTPConfig
+	for _, httpConfig := range m.httpConfigs {
+		if httpConfig.Domain != domain {
+			continue
+		}
+		// dns 地址模式下最后的容器正在摘除流量时，服务暂时没有可以生成的上游服务器
+		if len(httpConfig.servers()) > 0 || httpConfig.OnEmpty == config.OnEmptyMaintenance {
+			httpConfigs = append(httpConfigs, httpConfig)
+		}
+	}
+	sort.Slice(httpConfigs, func(i, j int) bool {
+		if httpConfigs[i].Path != httpConfigs[j].Path {
+			return httpConfigs[i].Path < httpConfigs[j].Path
+		}
+		return httpConfigs[i].ServiceName < httpConfigs[j].ServiceName
+	})
+	return httpConfigs
+}
+
+// serverName 获取域名的 server_name，包括域名下所有服务的域名别名
+func serverName(domain string, httpConfigs []*HTTPConfig) string {
+	var aliases []string
+	for _, httpConfig := range httpConfigs {
+		for _, alias := range httpConfig.Domains {
+			if alias != domain && !slices.Contains(aliases, alias) {
+				aliases = append(aliases, alias)
+			}
+		}
+	}
+	sort.Strings(aliases)
+	return strings.Join(append([]string{domain}, aliases...), " ")
+}
+
+// domainFileName 获取域名的HTTP配置文件名，通配符域名中的 * 替换为 _
+func domainFileName(domain string) string {
+	return strings.ReplaceAll(domain, "*", "_") + ".conf"
+}
+
 // generateStreamConfig 生成Stream配置文件
 func (m *Manager) generateStreamConfig(streamConfig *StreamConfig) error {
 	// 对于SNI配置，即使Upstream为空也要生成配置（使用StaticUpstreams）
-	if len(streamConfig.Upstream) == 0 && !streamConfig.EnableSNI {
+	if len(liveServers(streamConfig.Upstream)) == 0 && !streamConfig.EnableSNI {
 		// 如果没有上游服务器且不是SNI配置，删除配置文件
 		return m.deleteStreamConfig(streamConfig.ServiceName)
 	}
 
-	// 生成配置内容
-	configContent := m.buildStreamConfigContent(streamConfig)
+	// 生成配置内容，渲染失败时不写入文件
+	configContent, err := m.buildStreamConfigContent(streamConfig)
+	if err != nil {
+		return err
+	}
+	configContent = managedHeader("service", streamConfig.ServiceName, configContent) + configContent
 
 	// 写入配置文件
 	filename := fmt.Sprintf("%s.conf", streamConfig.ServiceName)
 	filepath := filepath.Join(m.config.Global.StreamConfigDir, filename)
 
-	if err := os.WriteFile(filepath, []byte(configContent), 0644); err != nil {
+	if err := m.writeConfigFile(filepath, []byte(configContent)); err != nil {
 		return fmt.Errorf("写入Stream配置文件失败 [%s]: %w", filename, err)
 	}
 
 	return nil
 }
 
-// buildHTTPConfigContent 构建HTTP配置内容
-func (m *Manager) buildHTTPConfigContent(httpConfig *HTTPConfig) string {
-	// 使用服务配置的代理配置，如果没有则使用全局默认配置
-	proxyConfig := httpConfig.ProxyConfig
-	if proxyConfig == nil {
-		proxyConfig = &m.config.Global.DefaultProxy
-	}
-
+// buildHTTPConfigContent 构建域名的HTTP配置内容
+func (m *Manager) buildHTTPConfigContent(domain string, httpConfigs []*HTTPConfig) (string, error) {
 	// 准备模板数据
+	// 同一域名的服务TLS配置相同
+	tls := httpConfigs[0].TLS
 	templateData := HTTPTemplateData{
-		ServiceName:          httpConfig.ServiceName,
-		Domain:               httpConfig.Domain,
-		Path:                 httpConfig.Path,
-		Upstream:             httpConfig.Upstream,
-		EnableWebSocket:      proxyConfig.EnableWebSocket,
-		ClientMaxBodySize:    proxyConfig.ClientMaxBodySize,
-		ProxyHTTPVersion:     proxyConfig.ProxyHTTPVersion,
-		ProxyHeaders:         proxyConfig.ProxyHeaders,
-		ProxyRedirect:        proxyConfig.ProxyRedirect,
+		Domain:     domain,
+		ServerName: serverName(domain, httpConfigs),
+		Locations:  make([]HTTPLocation, 0, len(httpConfigs)),
 		// SSL 配置
-		EnableSSL:            m.config.Global.SSLCertPath != "" && m.config.Global.SSLKeyPath != "",
-		SSLCertificate:       m.config.Global.SSLCertPath,
-		SSLCertificateKey:    m.config.Global.SSLKeyPath,
-		ForceHTTPS:           m.config.Global.ForceHTTPS,
+		EnableSSL:         tls.Enabled(),
+		SSLCertificate:    tls.Certificate,
+		SSLCertificateKey: tls.CertificateKey,
+		ForceHTTPS:        tls.ForceHTTPS,
+		HSTS:              tls.HSTS,
 	}
 
+	var upstream []UpstreamServer
+	for _, httpConfig := range httpConfigs {
+		// 使用服务配置的代理配置，如果没有则使用全局默认配置
+		proxyConfig := httpConfig.ProxyConfig
+		if proxyConfig == nil {
+			proxyConfig = &m.config.Global.DefaultProxy
+		}
+
+		servers := httpConfig.servers()
+		location := HTTPLocation{
+			ServiceName:       httpConfig.ServiceName,
+			UpstreamName:      httpConfig.UpstreamName,
+			Path:              httpConfig.Path,
+			Upstream:          splitTraffic(servers, httpConfig.Tracks),
+			Balance:           balanceDirective(httpConfig.UpstreamConfig),
+			Keepalive:         keepalive(httpConfig.UpstreamConfig),
+			EnableWebSocket:   proxyConfig.EnableWebSocket,
+			ClientMaxBodySize: proxyConfig.ClientMaxBodySize,
+			ProxyHTTPVersion:  proxyConfig.ProxyHTTPVersion,
+			ProxyHeaders:      proxyConfig.ProxyHeaders,
+			ProxyRedirect:     proxyConfig.ProxyRedirect,
+		}
+		if len(servers) == 0 {
+			// 没有上游服务器时不生成upstream块，location返回维护页面
+			location.Maintenance = true
+			location.MaintenanceRedirect = httpConfig.Maintenance.Redirect
+			if page := httpConfig.Maintenance.Page; page != "" {
+				location.MaintenanceRoot = path.Dir(page)
+				location.MaintenanceFile = path.Base(page)
+			}
+		}
+		templateData.Locations = append(templateData.Locations, location)
+		if proxyConfig.EnableWebSocket {
+			templateData.EnableWebSocket = true
+		}
+		upstream = append(upstream, servers...)
+	}
+	templateData.Resolver = m.resolver(upstream)
+
 	// 加载模板内容
 	templateContent, err := m.loadTemplate(m.config.Global.HTTPTemplateFile)
 	if err != nil {
-		log.Printf("加载HTTP配置模板失败: %v", err)
-		return ""
+		return "", fmt.Errorf("加载HTTP配置模板失败: %w", err)
 	}
 
 	// 解析模板
 	tmpl, err := template.New("httpConfig").Parse(templateContent)
 	if err != nil {
-		log.Printf("解析HTTP配置模板失败: %v", err)
-		return ""
+		return "", fmt.Errorf("解析HTTP配置模板失败: %w", err)
 	}
 
 	// 渲染模板
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, templateData); err != nil {
-		log.Printf("渲染HTTP配置模板失败: %v", err)
-		return ""
+		return "", fmt.Errorf("渲染HTTP配置模板失败: %w", err)
+	}
+
+	content := buf.String()
+	if strings.TrimSpace(content) == "" {
+		return "", fmt.Errorf("渲染HTTP配置模板失败: 生成的配置为空")
 	}
 
-	return buf.String()
+	return content, nil
 }
 
 // buildStreamConfigContent 构建Stream配置内容
-func (m *Manager) buildStreamConfigContent(streamConfig *StreamConfig) string {
+func (m *Manager) buildStreamConfigContent(streamConfig *StreamConfig) (string, error) {
 	// 准备模板数据
 	templateData := StreamTemplateData{
 		ServiceName:     streamConfig.ServiceName,
+		UpstreamName:    streamConfig.UpstreamName,
 		ListenPort:      streamConfig.ListenPort,
-		Upstream:        streamConfig.Upstream,
+		Upstream:        splitTraffic(liveServers(streamConfig.Upstream), streamConfig.Tracks),
 		EnableSNI:       streamConfig.EnableSNI,
 		DomainRoutes:    streamConfig.DomainRoutes,
-		DefaultRoute:    streamConfig.ServiceName,
+		DefaultRoute:    streamConfig.UpstreamName,
 		StaticUpstreams: streamConfig.StaticUpstreams,
+		Resolver:        m.resolver(streamConfig.Upstream),
+		Balance:         balanceDirective(streamConfig.UpstreamConfig),
+		ListenTCP:       streamConfig.Protocol != config.ProtocolUDP,
+		ListenUDP:       streamConfig.Protocol == config.ProtocolUDP || streamConfig.Protocol == config.ProtocolBoth,
+	}
+	if templateData.ListenUDP {
+		if streamConfig.ProxyResponses != nil {
+			templateData.ProxyResponses = strconv.Itoa(*streamConfig.ProxyResponses)
+		}
+		proxyTimeout := streamConfig.ProxyTimeout
+		if proxyTimeout <= 0 {
+			proxyTimeout = defaultUDPProxyTimeout
+		}
+		templateData.ProxyTimeout = nginxDuration(proxyTimeout)
 	}
 
 	// 选择合适的模板文件
@@ -339,38 +923,84 @@ func (m *Manager) buildStreamConfigContent(streamConfig *StreamConfig) string {
 	// 加载模板内容
 	templateContent, err := m.loadTemplate(templateFile)
 	if err != nil {
-		log.Printf("加载Stream配置模板失败: %v", err)
-		return ""
+		return "", fmt.Errorf("加载Stream配置模板失败: %w", err)
 	}
 
 	// 解析模板
 	tmpl, err := template.New("streamConfig").Parse(templateContent)
 	if err != nil {
-		log.Printf("解析Stream配置模板失败: %v", err)
-		return ""
+		return "", fmt.Errorf("解析Stream配置模板失败: %w", err)
 	}
 
 	// 渲染模板
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, templateData); err != nil {
-		log.Printf("渲染Stream配置模板失败: %v", err)
+		return "", fmt.Errorf("渲染Stream配置模板失败: %w", err)
+	}
+
+	content := buf.String()
+	if strings.TrimSpace(content) == "" {
+		return "", fmt.Errorf("渲染Stream配置模板失败: 生成的配置为空")
+	}
+
+	return content, nil
+}
+
+// resolver 获取写入配置的DNS解析服务器，上游服务器都是IP地址时返回空字符串
+func (m *Manager) resolver(upstream []UpstreamServer) string {
+	for _, server := range upstream {
+		if server.IsHostname() {
+			if m.config.Global.Resolver != "" {
+				return m.config.Global.Resolver
+			}
+			return defaultResolver
+		}
+	}
+	return ""
+}
+
+// balanceDirective 获取upstream块中的负载均衡指令，默认的轮询方式返回空字符串
+func balanceDirective(upstreamConfig *config.UpstreamConfig) string {
+	if upstreamConfig == nil {
+		return ""
+	}
+	switch upstreamConfig.Method {
+	case config.BalanceLeastConn, config.BalanceIPHash, config.BalanceRandom:
+		return upstreamConfig.Method
+	case config.BalanceHash:
+		if upstreamConfig.Consistent {
+			return fmt.Sprintf("hash %s consistent", upstreamConfig.HashKey)
+		}
+		return fmt.Sprintf("hash %s", upstreamConfig.HashKey)
+	default:
 		return ""
 	}
+}
 
-	return buf.String()
+// keepalive 获取upstream块中保持的空闲长连接数量
+func keepalive(upstreamConfig *config.UpstreamConfig) int {
+	if upstreamConfig == nil {
+		return 0
+	}
+	return upstreamConfig.Keepalive
 }
 
-// deleteHTTPConfig 删除HTTP配置文件
-func (m *Manager) deleteHTTPConfig(serviceName string) error {
-	filename := fmt.Sprintf("%s.conf", serviceName)
+// nginxDuration 将时间转换为nginx配置中的时间格式，如 10s、500ms
+func nginxDuration(d time.Duration) string {
+	if d%time.Second == 0 {
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+	return fmt.Sprintf("%dms", d.Milliseconds())
+}
+
+// deleteHTTPConfig 删除域名的HTTP配置文件
+func (m *Manager) deleteHTTPConfig(domain string) error {
+	filename := domainFileName(domain)
 	filepath := filepath.Join(m.config.Global.NginxConfigDir, filename)
 	
-	if err := os.Remove(filepath); err != nil && !os.IsNotExist(err) {
+	if err := m.removeConfigFile(filepath); err != nil {
 		return fmt.Errorf("删除HTTP配置文件失败: %w", err)
 	}
-	
-	// 从内存中移除配置
-	delete(m.httpConfigs, serviceName)
 	return nil
 }
 
@@ -379,7 +1009,7 @@ func (m *Manager) deleteStreamConfig(serviceName string) error {
 	filename := fmt.Sprintf("%s.conf", serviceName)
 	filepath := filepath.Join(m.config.Global.StreamConfigDir, filename)
 	
-	if err := os.Remove(filepath); err != nil && !os.IsNotExist(err) {
+	if err := m.removeConfigFile(filepath); err != nil {
 		return fmt.Errorf("删除Stream配置文件失败: %w", err)
 	}
 	
@@ -388,6 +1018,14 @@ func (m *Manager) deleteStreamConfig(serviceName string) error {
 	return nil
 }
 
+// ReloadConfig 重新加载与监听器共享的配置文件
+// 配置在原地替换，持有锁时替换可以避免与正在执行的重载和配置生成同时读写
+func (m *Manager) ReloadConfig() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.config.Reload()
+}
+
 // UpdateConfig 更新配置
 func (m *Manager) UpdateConfig(cfg *config.Config) {
 	m.mutex.Lock()
@@ -395,24 +1033,43 @@ func (m *Manager) UpdateConfig(cfg *config.Config) {
 	m.config = cfg
 }
 
-// Reload 重载nginx配置
+// Reload 测试并重载nginx配置
+// 每次更新时已测试过修改的配置文件，重载前再测试一次，测试失败时（如配置目录外的nginx配置有误）不重载
 func (m *Manager) Reload() error {
-	log.Printf("执行nginx重载命令: %s", m.config.Global.NginxReloadCmd)
-	
-	// 解析命令
-	parts := strings.Fields(m.config.Global.NginxReloadCmd)
-	if len(parts) == 0 {
-		return fmt.Errorf("nginx重载命令为空")
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.testConfig(); err != nil {
+		return fmt.Errorf("nginx配置测试失败，未重载: %w", err)
 	}
 
-	// 执行命令
-	cmd := exec.Command(parts[0], parts[1:]...)
-	output, err := cmd.CombinedOutput()
-	
+	log.Printf("重载nginx [方式: %s]", m.reloadMode())
+
+	output, err := m.reloadNginx()
 	if err != nil {
-		return fmt.Errorf("执行nginx重载命令失败: %w, 输出: %s", err, string(output))
+		return fmt.Errorf("重载nginx失败: %w, 输出: %s", err, output)
 	}
 
-	log.Printf("nginx重载成功: %s", string(output))
+	log.Printf("nginx重载成功: %s", output)
 	return nil
 }
+
+// testConfig 执行nginx配置测试
+func (m *Manager) testConfig() error {
+	tested, output, err := m.testNginx()
+	if err != nil {
+		return fmt.Errorf("%w, 输出: %s", err, output)
+	}
+	if tested {
+		log.Printf("nginx配置测试通过: %s", output)
+	}
+	return nil
+}
+
+// reloadMode 获取当前的重载方式
+func (m *Manager) reloadMode() string {
+	if m.config.Global.Reload.Mode == "" {
+		return ReloadModeCommand
+	}
+	return m.config.Global.Reload.Mode
+}
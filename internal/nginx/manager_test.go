@@ -0,0 +1,296 @@
+package nginx
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+
+	"docker-tool/internal/config"
+)
+
+// 包含该域名的配置文件无法通过测试
+const badDomain = "bad.example.com"
+
+// newTestManager 创建使用临时配置目录的管理器
+// 配置测试命令在任意配置文件包含 badDomain 时失败，重载命令向 reloads 文件追加一行
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	dir := t.TempDir()
+	httpDir := filepath.Join(dir, "http")
+	streamDir := filepath.Join(dir, "stream")
+	for _, d := range []string{httpDir, streamDir} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.Config{}
+	cfg.Global.NginxConfigDir = httpDir
+	cfg.Global.StreamConfigDir = streamDir
+	cfg.Global.HTTPTemplateFile = "../../conf/http.conf.tpl"
+	cfg.Global.StreamTemplateFile = "../../conf/stream.conf.tpl"
+	cfg.Global.Reload.TestCommand = []string{"sh", "-c", "! grep -qs " + badDomain + " " + httpDir + "/*.conf " + streamDir + "/*.conf"}
+	cfg.Global.Reload.Command = []string{"sh", "-c", "echo reload >> " + filepath.Join(dir, "reloads")}
+	return NewManager(cfg, nil)
+}
+
+func httpService(name string, domain string, path string) *config.ServiceConfig {
+	return &config.ServiceConfig{
+		Name:         name,
+		Type:         "http",
+		Domain:       domain,
+		Path:         path,
+		UpstreamName: name + "_backend",
+	}
+}
+
+func streamService(name string, listenPort int) *config.ServiceConfig {
+	return &config.ServiceConfig{
+		Name:         name,
+		Type:         "stream",
+		ListenPort:   listenPort,
+		UpstreamName: name + "_backend",
+	}
+}
+
+// register 将容器注册为服务的上游服务器
+func register(t *testing.T, m *Manager, service *config.ServiceConfig, containerID string, ip string) error {
+	t.Helper()
+	return m.UpdateService(service, containerID, []string{ip}, nat.Port("80/tcp"), config.ServerOptions{})
+}
+
+func readConfig(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("读取配置文件失败: %v", err)
+	}
+	return string(content)
+}
+
+func assertNotExist(t *testing.T, path string) {
+	t.Helper()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("配置文件 %s 不应存在", path)
+	}
+}
+
+func TestUpdateServiceRollsBackFailedTest(t *testing.T) {
+	m := newTestManager(t)
+	httpDir := m.config.Global.NginxConfigDir
+
+	if err := register(t, m, httpService("api", "a.example.com", "/"), "c1", "10.0.0.1"); err != nil {
+		t.Fatalf("注册容器失败: %v", err)
+	}
+	if err := register(t, m, httpService("web", "b.example.com", "/"), "c2", "10.0.0.2"); err != nil {
+		t.Fatalf("注册容器失败: %v", err)
+	}
+	original := readConfig(t, filepath.Join(httpDir, "a.example.com.conf"))
+
+	tests := []struct {
+		name        string
+		service     *config.ServiceConfig
+		containerID string
+		ip          string
+		// 更新失败后服务的域名，为空时服务不应存在
+		wantDomain string
+		// 更新失败后容器注册的服务，为空时容器不应注册
+		wantService string
+	}{
+		{
+			name:        "新服务的配置未通过测试",
+			service:     httpService("new", badDomain, "/"),
+			containerID: "c3",
+			ip:          "10.0.0.3",
+		},
+		{
+			name:        "服务换到未通过测试的域名",
+			service:     httpService("api", badDomain, "/"),
+			containerID: "c1",
+			ip:          "10.0.0.1",
+			wantDomain:  "a.example.com",
+			wantService: "api",
+		},
+		{
+			name:        "容器换到未通过测试的服务",
+			service:     httpService("other", badDomain, "/other"),
+			containerID: "c2",
+			ip:          "10.0.0.2",
+			wantService: "web",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := register(t, m, tt.service, tt.containerID, tt.ip)
+			if err == nil {
+				t.Fatal("期望配置测试失败")
+			}
+
+			assertNotExist(t, filepath.Join(httpDir, badDomain+".conf"))
+			if got := readConfig(t, filepath.Join(httpDir, "a.example.com.conf")); got != original {
+				t.Errorf("a.example.com.conf 未恢复:\n%s", got)
+			}
+			if !strings.Contains(readConfig(t, filepath.Join(httpDir, "b.example.com.conf")), "10.0.0.2:80") {
+				t.Error("b.example.com.conf 不应被修改")
+			}
+
+			httpConfig, exists := m.httpConfigs[tt.service.Name]
+			if tt.wantDomain == "" && exists {
+				t.Errorf("服务 %s 不应存在", tt.service.Name)
+			}
+			if tt.wantDomain != "" && (!exists || httpConfig.Domain != tt.wantDomain) {
+				t.Errorf("服务 %s 的域名未恢复为 %s", tt.service.Name, tt.wantDomain)
+			}
+			entry, registered := m.GetContainer(tt.containerID)
+			if tt.wantService == "" && registered {
+				t.Errorf("容器 %s 不应注册", tt.containerID)
+			}
+			if tt.wantService != "" && (!registered || entry.ServiceName != tt.wantService) {
+				t.Errorf("容器 %s 应注册到服务 %s, 实际 %+v", tt.containerID, tt.wantService, entry)
+			}
+			if len(m.backups) != 0 {
+				t.Errorf("备份未清空: %d", len(m.backups))
+			}
+		})
+	}
+}
+
+func TestUpdateServiceMovesDomain(t *testing.T) {
+	m := newTestManager(t)
+	httpDir := m.config.Global.NginxConfigDir
+
+	if err := register(t, m, httpService("api", "a.example.com", "/"), "c1", "10.0.0.1"); err != nil {
+		t.Fatalf("注册容器失败: %v", err)
+	}
+	if err := register(t, m, httpService("api", "c.example.com", "/"), "c1", "10.0.0.1"); err != nil {
+		t.Fatalf("更换域名失败: %v", err)
+	}
+
+	assertNotExist(t, filepath.Join(httpDir, "a.example.com.conf"))
+	if !strings.Contains(readConfig(t, filepath.Join(httpDir, "c.example.com.conf")), "server 10.0.0.1:80") {
+		t.Error("c.example.com.conf 中没有上游服务器")
+	}
+}
+
+func TestUpdateServiceRollsBackWriteError(t *testing.T) {
+	m := newTestManager(t)
+	httpDir := m.config.Global.NginxConfigDir
+
+	// 不是由本程序生成的同名文件不会被覆盖
+	unmanaged := filepath.Join(httpDir, "a.example.com.conf")
+	if err := os.WriteFile(unmanaged, []byte("server {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := register(t, m, httpService("api", "a.example.com", "/"), "c1", "10.0.0.1"); err == nil {
+		t.Fatal("期望写入失败")
+	}
+	if _, registered := m.GetContainer("c1"); registered {
+		t.Error("写入失败时容器不应注册")
+	}
+	if m.HasService("api") {
+		t.Error("写入失败时服务不应存在")
+	}
+	if got := readConfig(t, unmanaged); got != "server {}\n" {
+		t.Errorf("文件被修改: %s", got)
+	}
+}
+
+func TestRemoveContainerRollsBackFailedTest(t *testing.T) {
+	m := newTestManager(t)
+	streamDir := m.config.Global.StreamConfigDir
+
+	if err := register(t, m, streamService("db", 3306), "c1", "10.0.0.1"); err != nil {
+		t.Fatalf("注册容器失败: %v", err)
+	}
+	if err := register(t, m, streamService("db", 3306), "c2", "10.0.0.2"); err != nil {
+		t.Fatalf("注册容器失败: %v", err)
+	}
+	path := filepath.Join(streamDir, "db.conf")
+	original := readConfig(t, path)
+
+	// 配置目录中出现无效的配置后，移除容器的修改无法通过测试
+	broken := filepath.Join(streamDir, "broken.conf")
+	if err := os.WriteFile(broken, []byte(badDomain), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.RemoveContainer("c2"); err == nil {
+		t.Fatal("期望配置测试失败")
+	}
+	if got := readConfig(t, path); got != original {
+		t.Errorf("db.conf 未恢复:\n%s", got)
+	}
+	if _, registered := m.GetContainer("c2"); !registered {
+		t.Error("容器 c2 的注册信息未恢复")
+	}
+
+	if err := os.Remove(broken); err != nil {
+		t.Fatal(err)
+	}
+	serviceName, err := m.RemoveContainer("c2")
+	if err != nil || serviceName != "db" {
+		t.Fatalf("RemoveContainer() = %q, %v", serviceName, err)
+	}
+	if strings.Contains(readConfig(t, path), "10.0.0.2") {
+		t.Error("容器 c2 的上游服务器未移除")
+	}
+}
+
+func TestReloadSkipsFailedTest(t *testing.T) {
+	tests := []struct {
+		name        string
+		broken      bool
+		wantReloads int
+	}{
+		{"配置测试通过时重载", false, 1},
+		{"配置测试失败时不重载", true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t)
+			if tt.broken {
+				broken := filepath.Join(m.config.Global.NginxConfigDir, "broken.conf")
+				if err := os.WriteFile(broken, []byte(badDomain), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := m.Reload()
+			if (err != nil) != tt.broken {
+				t.Errorf("Reload() 错误 = %v", err)
+			}
+			reloads := 0
+			if content, err := os.ReadFile(filepath.Join(filepath.Dir(m.config.Global.NginxConfigDir), "reloads")); err == nil {
+				reloads = strings.Count(string(content), "\n")
+			}
+			if reloads != tt.wantReloads {
+				t.Errorf("重载次数 = %d, 期望 %d", reloads, tt.wantReloads)
+			}
+		})
+	}
+}
+
+func TestRemoveContainerWithoutEntry(t *testing.T) {
+	m := newTestManager(t)
+	path := filepath.Join(m.config.Global.StreamConfigDir, "db.conf")
+
+	if err := register(t, m, streamService("db", 3306), "c1", "10.0.0.1"); err != nil {
+		t.Fatalf("注册容器失败: %v", err)
+	}
+	// 没有注册信息时从上游服务器列表中查找容器
+	delete(m.containers, "c1")
+
+	serviceName, err := m.RemoveContainer("c1")
+	if err != nil || serviceName != "db" {
+		t.Fatalf("RemoveContainer() = %q, %v, 期望 db", serviceName, err)
+	}
+	assertNotExist(t, path)
+
+	if serviceName, err := m.RemoveContainer("c2"); err != nil || serviceName != "" {
+		t.Errorf("未知容器 RemoveContainer() = %q, %v", serviceName, err)
+	}
+}
@@ -0,0 +1,195 @@
+package nginx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"docker-tool/internal/config"
+)
+
+// 重载方式
+const (
+	ReloadModeCommand      = "command"
+	ReloadModeDockerExec   = "docker_exec"
+	ReloadModeDockerSignal = "docker_signal"
+	ReloadModePIDFile      = "pidfile"
+)
+
+// 执行重载和测试的超时时间
+const reloadTimeout = 30 * time.Second
+
+// 默认在nginx容器内执行的重载命令
+var defaultDockerExecReloadCmd = []string{"nginx", "-s", "reload"}
+
+// reloadNginx 按配置的重载方式重载nginx，返回命令输出
+func (m *Manager) reloadNginx() (string, error) {
+	reload := m.config.Global.Reload
+
+	ctx, cancel := context.WithTimeout(context.Background(), reloadTimeout)
+	defer cancel()
+
+	switch reload.Mode {
+	case "", ReloadModeCommand:
+		return runCommand(ctx, m.reloadCommand())
+	case ReloadModeDockerExec:
+		command := reload.Command
+		if len(command) == 0 {
+			command = defaultDockerExecReloadCmd
+		}
+		return m.dockerExec(ctx, reload.Container, command)
+	case ReloadModeDockerSignal:
+		if m.dockerClient == nil {
+			return "", fmt.Errorf("docker_signal 重载方式需要Docker客户端")
+		}
+		signal := reload.Signal
+		if signal == "" {
+			signal = "SIGHUP"
+		}
+		if err := m.dockerClient.ContainerKill(ctx, reload.Container, signal); err != nil {
+			return "", fmt.Errorf("向容器 %s 发送信号 %s 失败: %w", reload.Container, signal, err)
+		}
+		return fmt.Sprintf("已向容器 %s 发送信号 %s", reload.Container, signal), nil
+	case ReloadModePIDFile:
+		return signalPIDFile(reload.PIDFile, reload.Signal)
+	default:
+		return "", fmt.Errorf("不支持的重载方式: %s", reload.Mode)
+	}
+}
+
+// testNginx 执行nginx配置测试，未配置测试命令时返回 false
+func (m *Manager) testNginx() (bool, string, error) {
+	reload := m.config.Global.Reload
+
+	ctx, cancel := context.WithTimeout(context.Background(), reloadTimeout)
+	defer cancel()
+
+	if reload.Mode == ReloadModeDockerExec && len(reload.TestCommand) > 0 {
+		output, err := m.dockerExec(ctx, reload.Container, reload.TestCommand)
+		return true, output, err
+	}
+
+	command := m.testCommand()
+	if len(command) == 0 {
+		return false, "", nil
+	}
+	output, err := runCommand(ctx, command)
+	return true, output, err
+}
+
+// reloadCommand 获取command方式的重载命令，兼容 nginx_reload_cmd
+func (m *Manager) reloadCommand() []string {
+	if len(m.config.Global.Reload.Command) > 0 {
+		return m.config.Global.Reload.Command
+	}
+	return config.SplitCommand(m.config.Global.NginxReloadCmd)
+}
+
+// testCommand 获取在本机执行的配置测试命令，兼容 nginx_test_cmd
+func (m *Manager) testCommand() []string {
+	if len(m.config.Global.Reload.TestCommand) > 0 {
+		return m.config.Global.Reload.TestCommand
+	}
+	return config.SplitCommand(m.config.Global.NginxTestCmd)
+}
+
+// runCommand 执行命令并返回合并后的输出
+func runCommand(ctx context.Context, command []string) (string, error) {
+	if len(command) == 0 {
+		return "", fmt.Errorf("命令为空")
+	}
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+// dockerExec 在容器内执行命令并返回输出，命令退出码非0时返回错误
+func (m *Manager) dockerExec(ctx context.Context, container string, command []string) (string, error) {
+	if m.dockerClient == nil {
+		return "", fmt.Errorf("docker_exec 重载方式需要Docker客户端")
+	}
+
+	execResp, err := m.dockerClient.ContainerExecCreate(ctx, container, types.ExecConfig{
+		Cmd:          command,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("在容器 %s 中创建exec失败: %w", container, err)
+	}
+
+	attachResp, err := m.dockerClient.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{})
+	if err != nil {
+		return "", fmt.Errorf("在容器 %s 中执行命令失败: %w", container, err)
+	}
+	defer attachResp.Close()
+
+	var output bytes.Buffer
+	if _, err := stdcopy.StdCopy(&output, &output, attachResp.Reader); err != nil {
+		return output.String(), fmt.Errorf("读取容器 %s 中的命令输出失败: %w", container, err)
+	}
+
+	inspect, err := m.dockerClient.ContainerExecInspect(ctx, execResp.ID)
+	if err != nil {
+		return output.String(), fmt.Errorf("获取容器 %s 中的命令执行结果失败: %w", container, err)
+	}
+	if inspect.ExitCode != 0 {
+		return output.String(), fmt.Errorf("容器 %s 中的命令 %s 退出码为 %d", container, strings.Join(command, " "), inspect.ExitCode)
+	}
+
+	return output.String(), nil
+}
+
+// signalPIDFile 向pid文件中记录的本机进程发送信号
+func signalPIDFile(pidFile string, signalName string) (string, error) {
+	content, err := os.ReadFile(pidFile)
+	if err != nil {
+		return "", fmt.Errorf("读取pid文件失败: %w", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return "", fmt.Errorf("pid文件 %s 内容无效: %w", pidFile, err)
+	}
+
+	signal, err := parseSignal(signalName)
+	if err != nil {
+		return "", err
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return "", fmt.Errorf("查找进程 %d 失败: %w", pid, err)
+	}
+	if err := process.Signal(signal); err != nil {
+		return "", fmt.Errorf("向进程 %d 发送信号失败: %w", pid, err)
+	}
+	return fmt.Sprintf("已向进程 %d 发送信号 %s", pid, signal), nil
+}
+
+// parseSignal 解析信号名称，为空时默认为 SIGHUP
+func parseSignal(name string) (syscall.Signal, error) {
+	switch strings.TrimPrefix(strings.ToUpper(name), "SIG") {
+	case "", "HUP":
+		return syscall.SIGHUP, nil
+	case "USR1":
+		return syscall.SIGUSR1, nil
+	case "USR2":
+		return syscall.SIGUSR2, nil
+	case "QUIT":
+		return syscall.SIGQUIT, nil
+	case "TERM":
+		return syscall.SIGTERM, nil
+	default:
+		return 0, fmt.Errorf("不支持的信号: %s", name)
+	}
+}
@@ -0,0 +1,242 @@
+package nginx
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"docker-tool/internal/config"
+)
+
+// upstreamServers 创建使用80端口的上游服务器
+func upstreamServers(ips ...string) []UpstreamServer {
+	servers := make([]UpstreamServer, 0, len(ips))
+	for _, ip := range ips {
+		servers = append(servers, UpstreamServer{ContainerID: ip, IP: ip, Port: "80/tcp"})
+	}
+	return servers
+}
+
+// assertContent 检查生成的配置包含 want 中的所有内容，且不包含 notWant 中的任何内容
+func assertContent(t *testing.T, content string, want []string, notWant []string) {
+	t.Helper()
+	for _, s := range want {
+		if !strings.Contains(content, s) {
+			t.Errorf("配置中缺少 %q:\n%s", s, content)
+		}
+	}
+	for _, s := range notWant {
+		if strings.Contains(content, s) {
+			t.Errorf("配置中不应包含 %q:\n%s", s, content)
+		}
+	}
+}
+
+func TestBuildHTTPConfigContent(t *testing.T) {
+	tests := []struct {
+		name        string
+		httpConfigs []*HTTPConfig
+		want        []string
+		notWant     []string
+	}{
+		{
+			name: "同一域名的多个location",
+			httpConfigs: []*HTTPConfig{
+				{ServiceName: "api", UpstreamName: "api_backend", Path: "/", Upstream: upstreamServers("10.0.0.1"), Domains: []string{"www.example.com"}},
+				{ServiceName: "web", UpstreamName: "web_backend", Path: "/web/", Upstream: upstreamServers("10.0.0.2", "10.0.0.3")},
+			},
+			want: []string{
+				"server_name a.example.com www.example.com;",
+				"upstream api_backend {\n    server 10.0.0.1:80;\n}",
+				"upstream web_backend {\n    server 10.0.0.2:80;\n    server 10.0.0.3:80;\n}",
+				"location / {",
+				"proxy_pass http://api_backend/;",
+				"location /web/ {",
+				"proxy_pass http://web_backend/;",
+			},
+			notWant: []string{"resolver", "keepalive", "map $http_upgrade"},
+		},
+		{
+			name: "keepalive和负载均衡",
+			httpConfigs: []*HTTPConfig{
+				{
+					ServiceName: "api", UpstreamName: "api_backend", Path: "/", Upstream: upstreamServers("10.0.0.1"),
+					UpstreamConfig: &config.UpstreamConfig{Method: config.BalanceHash, HashKey: "$request_uri", Consistent: true, Keepalive: 16},
+				},
+			},
+			want: []string{
+				"upstream api_backend {\n    hash $request_uri consistent;\n    server 10.0.0.1:80;\n    keepalive 16;\n}",
+				"proxy_http_version 1.1;",
+				`proxy_set_header Connection "";`,
+			},
+		},
+		{
+			name: "keepalive使用配置的HTTP版本",
+			httpConfigs: []*HTTPConfig{
+				{
+					ServiceName: "api", UpstreamName: "api_backend", Path: "/", Upstream: upstreamServers("10.0.0.1"),
+					UpstreamConfig: &config.UpstreamConfig{Keepalive: 16},
+					ProxyConfig:    &config.ProxyConfig{ProxyHTTPVersion: "1.0"},
+				},
+			},
+			want:    []string{"proxy_http_version 1.0;", `proxy_set_header Connection "";`},
+			notWant: []string{"proxy_http_version 1.1;"},
+		},
+		{
+			name: "keepalive和WebSocket",
+			httpConfigs: []*HTTPConfig{
+				{
+					ServiceName: "api", UpstreamName: "api_backend", Path: "/", Upstream: upstreamServers("10.0.0.1"),
+					UpstreamConfig: &config.UpstreamConfig{Keepalive: 16},
+					ProxyConfig:    &config.ProxyConfig{EnableWebSocket: true},
+				},
+			},
+			want:    []string{"map $http_upgrade $connection_upgrade", "proxy_set_header Connection $connection_upgrade;", "keepalive 16;"},
+			notWant: []string{`proxy_set_header Connection "";`},
+		},
+		{
+			name: "维护重定向",
+			httpConfigs: []*HTTPConfig{
+				{
+					ServiceName: "api", UpstreamName: "api_backend", Path: "/",
+					OnEmpty: config.OnEmptyMaintenance, Maintenance: config.MaintenanceConfig{Redirect: "https://status.example.com/"},
+				},
+			},
+			want:    []string{"# 服务 api 没有可用的上游服务器", "return 302 https://status.example.com/;"},
+			notWant: []string{"upstream api_backend", "proxy_pass", "error_page"},
+		},
+		{
+			name: "维护页面",
+			httpConfigs: []*HTTPConfig{
+				{
+					ServiceName: "api", UpstreamName: "api_backend", Path: "/",
+					OnEmpty: config.OnEmptyMaintenance, Maintenance: config.MaintenanceConfig{Page: "/usr/share/nginx/html/maintenance.html"},
+				},
+			},
+			want: []string{
+				"error_page 503 @api_backend_maintenance;",
+				"location @api_backend_maintenance {\n        root /usr/share/nginx/html;\n        try_files /maintenance.html =503;\n    }",
+			},
+			notWant: []string{"upstream api_backend", "proxy_pass", "return 302"},
+		},
+		{
+			name: "纯文本维护响应",
+			httpConfigs: []*HTTPConfig{
+				{ServiceName: "api", UpstreamName: "api_backend", Path: "/", OnEmpty: config.OnEmptyMaintenance},
+			},
+			want:    []string{"default_type text/plain;", `return 503 "Service Temporarily Unavailable\n";`},
+			notWant: []string{"upstream api_backend", "proxy_pass", "error_page"},
+		},
+		{
+			name: "维护中的服务不影响同一域名的其他服务",
+			httpConfigs: []*HTTPConfig{
+				{ServiceName: "api", UpstreamName: "api_backend", Path: "/", OnEmpty: config.OnEmptyMaintenance},
+				{ServiceName: "web", UpstreamName: "web_backend", Path: "/web/", Upstream: upstreamServers("10.0.0.2")},
+			},
+			want:    []string{"return 503", "upstream web_backend", "proxy_pass http://web_backend/;"},
+			notWant: []string{"upstream api_backend", "proxy_pass http://api_backend/;"},
+		},
+		{
+			name: "keep保留最后的上游服务器",
+			httpConfigs: []*HTTPConfig{
+				{ServiceName: "api", UpstreamName: "api_backend", Path: "/", OnEmpty: config.OnEmptyKeep, lastUpstream: upstreamServers("10.0.0.1")},
+			},
+			want:    []string{"upstream api_backend {\n    server 10.0.0.1:80;\n}", "proxy_pass http://api_backend/;"},
+			notWant: []string{"return 503"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t)
+			content, err := m.buildHTTPConfigContent("a.example.com", tt.httpConfigs)
+			if err != nil {
+				t.Fatalf("buildHTTPConfigContent() 错误: %v", err)
+			}
+			assertContent(t, content, tt.want, tt.notWant)
+		})
+	}
+}
+
+func TestHTTPConfigLocationsSorted(t *testing.T) {
+	m := newTestManager(t)
+	for _, service := range []*config.ServiceConfig{
+		httpService("web", "a.example.com", "/web/"),
+		httpService("api", "a.example.com", "/api/"),
+		httpService("home", "a.example.com", "/"),
+	} {
+		if err := register(t, m, service, service.Name, "10.0.0.1"); err != nil {
+			t.Fatalf("注册服务 %s 失败: %v", service.Name, err)
+		}
+	}
+
+	content := readConfig(t, filepath.Join(m.config.Global.NginxConfigDir, "a.example.com.conf"))
+	last := -1
+	for _, location := range []string{"location / {", "location /api/ {", "location /web/ {"} {
+		index := strings.Index(content, location)
+		if index <= last {
+			t.Fatalf("%s 的位置 = %d, 期望在 %d 之后:\n%s", location, index, last, content)
+		}
+		last = index
+	}
+}
+
+func TestBuildStreamConfigContent(t *testing.T) {
+	responses := 1
+
+	tests := []struct {
+		name         string
+		streamConfig *StreamConfig
+		want         []string
+		notWant      []string
+	}{
+		{
+			name:         "TCP",
+			streamConfig: &StreamConfig{ServiceName: "db", UpstreamName: "db_backend", ListenPort: 3306, Upstream: upstreamServers("10.0.0.1")},
+			want:         []string{"upstream db_backend {\n    server 10.0.0.1:80;\n}", "listen 3306;", "proxy_pass db_backend;"},
+			notWant:      []string{"udp", "proxy_timeout"},
+		},
+		{
+			name: "UDP使用默认超时时间",
+			streamConfig: &StreamConfig{
+				ServiceName: "dns", UpstreamName: "dns_backend", ListenPort: 53, Upstream: upstreamServers("10.0.0.1"),
+				Protocol: config.ProtocolUDP,
+			},
+			want:    []string{"listen 53 udp;", "proxy_timeout 10s;"},
+			notWant: []string{"listen 53;", "proxy_responses"},
+		},
+		{
+			name: "同时监听TCP和UDP",
+			streamConfig: &StreamConfig{
+				ServiceName: "dns", UpstreamName: "dns_backend", ListenPort: 53, Upstream: upstreamServers("10.0.0.1"),
+				Protocol: config.ProtocolBoth, ProxyResponses: &responses, ProxyTimeout: 1500 * time.Millisecond,
+			},
+			want: []string{"listen 53;", "listen 53 udp;", "proxy_responses 1;", "proxy_timeout 1500ms;"},
+		},
+		{
+			name: "负载均衡",
+			streamConfig: &StreamConfig{
+				ServiceName: "db", UpstreamName: "db_backend", ListenPort: 3306, Upstream: upstreamServers("10.0.0.1", "10.0.0.2"),
+				UpstreamConfig: &config.UpstreamConfig{Method: config.BalanceLeastConn},
+			},
+			want: []string{"upstream db_backend {\n    least_conn;\n    server 10.0.0.1:80;\n    server 10.0.0.2:80;\n}"},
+		},
+		{
+			name:         "上游服务器使用容器名称",
+			streamConfig: &StreamConfig{ServiceName: "db", UpstreamName: "db_backend", ListenPort: 3306, Upstream: upstreamServers("db-1")},
+			want:         []string{"server db-1:80;", "resolver " + defaultResolver + ";"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t)
+			content, err := m.buildStreamConfigContent(tt.streamConfig)
+			if err != nil {
+				t.Fatalf("buildStreamConfigContent() 错误: %v", err)
+			}
+			assertContent(t, content, tt.want, tt.notWant)
+		})
+	}
+}
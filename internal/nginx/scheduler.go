@@ -0,0 +1,170 @@
+package nginx
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认的重载合并时间窗口
+const (
+	DefaultReloadDebounce = 500 * time.Millisecond
+	DefaultReloadMaxDelay = 5 * time.Second
+)
+
+// ReloadScheduler nginx重载调度器
+// 将一段时间内的多次配置变更合并为一次重载，并保证同一时间只有一个重载在执行
+type ReloadScheduler struct {
+	manager  *Manager
+	debounce time.Duration
+	maxDelay time.Duration
+
+	pending map[string]struct{}
+	// 第一个未处理的重载请求的时间
+	firstRequest time.Time
+	// 最后一个未处理的重载请求的时间
+	lastRequest time.Time
+	// 重载失败后下一次重试的时间
+	retryAt time.Time
+	notify  chan struct{}
+	mutex   sync.Mutex
+}
+
+// NewReloadScheduler 创建nginx重载调度器
+func NewReloadScheduler(manager *Manager, debounce time.Duration, maxDelay time.Duration) *ReloadScheduler {
+	if debounce <= 0 {
+		debounce = DefaultReloadDebounce
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultReloadMaxDelay
+	}
+	if maxDelay < debounce {
+		maxDelay = debounce
+	}
+
+	return &ReloadScheduler{
+		manager:  manager,
+		debounce: debounce,
+		maxDelay: maxDelay,
+		pending:  make(map[string]struct{}),
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// Request 请求重载nginx，serviceName 为触发重载的服务
+func (s *ReloadScheduler) Request(serviceName string) {
+	s.mutex.Lock()
+	now := time.Now()
+	if len(s.pending) == 0 {
+		s.firstRequest = now
+	}
+	s.lastRequest = now
+	s.pending[serviceName] = struct{}{}
+	s.mutex.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run 运行调度循环，直到上下文取消
+func (s *ReloadScheduler) Run(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// 退出前执行尚未处理的重载
+			s.flush()
+			return
+		case <-s.notify:
+			s.resetTimer(timer)
+		case <-timer.C:
+			s.flush()
+			// 重载期间可能有新的请求
+			s.resetTimer(timer)
+		}
+	}
+}
+
+// resetTimer 根据未处理的请求计算下一次重载的时间
+func (s *ReloadScheduler) resetTimer(timer *time.Timer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	if len(s.pending) == 0 {
+		return
+	}
+	timer.Reset(time.Until(s.nextReload()))
+}
+
+// nextReload 计算下一次重载的时间，在最后一次请求后等待 debounce，但从第一次请求起最多等待 maxDelay
+// 上一次重载失败时不早于重试时间
+func (s *ReloadScheduler) nextReload() time.Time {
+	fireAt := s.lastRequest.Add(s.debounce)
+	if deadline := s.firstRequest.Add(s.maxDelay); fireAt.After(deadline) {
+		fireAt = deadline
+	}
+	if fireAt.Before(s.retryAt) {
+		fireAt = s.retryAt
+	}
+	return fireAt
+}
+
+// flush 执行一次重载，包含所有未处理的请求
+func (s *ReloadScheduler) flush() {
+	s.mutex.Lock()
+	if len(s.pending) == 0 {
+		s.mutex.Unlock()
+		return
+	}
+	services := make([]string, 0, len(s.pending))
+	for name := range s.pending {
+		services = append(services, name)
+	}
+	s.pending = make(map[string]struct{})
+	s.mutex.Unlock()
+
+	sort.Strings(services)
+	serviceList := strings.Join(services, ", ")
+
+	// 配置文件在每次更新时已经测试过，这里只执行重载
+	if err := s.manager.Reload(); err != nil {
+		log.Printf("警告: 重载nginx失败，将在 %s 后重试 [服务: %s]: %v", s.maxDelay, serviceList, err)
+		s.requeue(services)
+		return
+	}
+
+	s.mutex.Lock()
+	s.retryAt = time.Time{}
+	s.mutex.Unlock()
+	log.Printf("成功: nginx已重载，包含服务: %s", serviceList)
+}
+
+// requeue 重载失败后将服务重新加入未处理的请求，在 maxDelay 后重试
+func (s *ReloadScheduler) requeue(services []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if len(s.pending) == 0 {
+		s.firstRequest = now
+		s.lastRequest = now
+	}
+	for _, name := range services {
+		s.pending[name] = struct{}{}
+	}
+	s.retryAt = now.Add(s.maxDelay)
+}
@@ -0,0 +1,187 @@
+package nginx
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"docker-tool/internal/config"
+)
+
+func TestNewReloadSchedulerDefaults(t *testing.T) {
+	tests := []struct {
+		name         string
+		debounce     time.Duration
+		maxDelay     time.Duration
+		wantDebounce time.Duration
+		wantMaxDelay time.Duration
+	}{
+		{"未配置时使用默认值", 0, 0, DefaultReloadDebounce, DefaultReloadMaxDelay},
+		{"负数时使用默认值", -time.Second, -time.Second, DefaultReloadDebounce, DefaultReloadMaxDelay},
+		{"使用配置的值", 100 * time.Millisecond, time.Second, 100 * time.Millisecond, time.Second},
+		{"maxDelay小于debounce时使用debounce", 2 * time.Second, time.Second, 2 * time.Second, 2 * time.Second},
+		{"只配置debounce且大于默认maxDelay", 10 * time.Second, 0, 10 * time.Second, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewReloadScheduler(nil, tt.debounce, tt.maxDelay)
+			if s.debounce != tt.wantDebounce || s.maxDelay != tt.wantMaxDelay {
+				t.Errorf("debounce, maxDelay = %s, %s, 期望 %s, %s", s.debounce, s.maxDelay, tt.wantDebounce, tt.wantMaxDelay)
+			}
+		})
+	}
+}
+
+func TestReloadSchedulerNextReload(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		first time.Duration
+		last  time.Duration
+		// 重载失败后的重试时间，为0时表示没有失败
+		retry time.Duration
+		want  time.Duration
+	}{
+		{"单个请求在debounce后重载", 0, 0, 0, 500 * time.Millisecond},
+		{"最后一次请求后重新等待debounce", 0, 2 * time.Second, 0, 2500 * time.Millisecond},
+		{"持续的请求最多等待maxDelay", 0, 4800 * time.Millisecond, 0, 5 * time.Second},
+		{"刚好达到maxDelay", 0, 4500 * time.Millisecond, 0, 5 * time.Second},
+		{"重载失败后等待重试时间", 0, 0, 5 * time.Second, 5 * time.Second},
+		{"重试时间已过时按请求时间重载", 0, 2 * time.Second, time.Second, 2500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewReloadScheduler(nil, 500*time.Millisecond, 5*time.Second)
+			s.firstRequest = base.Add(tt.first)
+			s.lastRequest = base.Add(tt.last)
+			if tt.retry > 0 {
+				s.retryAt = base.Add(tt.retry)
+			}
+			if got := s.nextReload(); !got.Equal(base.Add(tt.want)) {
+				t.Errorf("nextReload() = +%s, 期望 +%s", got.Sub(base), tt.want)
+			}
+		})
+	}
+}
+
+// newCountingManager 创建每次重载时向文件追加一行的管理器，用于统计重载次数，exitCode 为重载命令的退出码
+func newCountingManager(t *testing.T, exitCode int) (*Manager, func() int) {
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "reloads")
+	cfg := &config.Config{}
+	cfg.Global.NginxConfigDir = dir
+	cfg.Global.StreamConfigDir = dir
+	cfg.Global.Reload.Command = []string{"sh", "-c", fmt.Sprintf("echo reload >> %s; exit %d", counter, exitCode)}
+
+	count := func() int {
+		content, err := os.ReadFile(counter)
+		if err != nil {
+			return 0
+		}
+		return strings.Count(string(content), "\n")
+	}
+	return NewManager(cfg, nil), count
+}
+
+func TestReloadSchedulerCoalescesRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		debounce time.Duration
+		maxDelay time.Duration
+		// 请求次数和间隔
+		requests int
+		interval time.Duration
+		min, max int
+	}{
+		{"连续的请求合并为一次重载", 100 * time.Millisecond, time.Second, 5, 0, 1, 1},
+		{"持续的请求在maxDelay后重载", 100 * time.Millisecond, 200 * time.Millisecond, 12, 50 * time.Millisecond, 2, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, count := newCountingManager(t, 0)
+			s := NewReloadScheduler(manager, tt.debounce, tt.maxDelay)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.Run(ctx)
+			}()
+
+			for i := 0; i < tt.requests; i++ {
+				s.Request("service")
+				time.Sleep(tt.interval)
+			}
+			time.Sleep(tt.debounce + 200*time.Millisecond)
+			cancel()
+			wg.Wait()
+
+			if got := count(); got < tt.min || got > tt.max {
+				t.Errorf("重载次数 = %d, 期望 %d 到 %d", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestReloadSchedulerFlushOnCancel(t *testing.T) {
+	manager, count := newCountingManager(t, 0)
+	s := NewReloadScheduler(manager, time.Hour, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	s.Request("service")
+	cancel()
+	<-done
+
+	if got := count(); got != 1 {
+		t.Errorf("退出前重载次数 = %d, 期望 1", got)
+	}
+}
+
+func TestReloadSchedulerRetriesFailedReload(t *testing.T) {
+	tests := []struct {
+		name     string
+		exitCode int
+		min, max int
+	}{
+		{"重载成功时不重试", 0, 1, 1},
+		{"重载失败时在maxDelay后重试", 1, 3, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, count := newCountingManager(t, tt.exitCode)
+			s := NewReloadScheduler(manager, 50*time.Millisecond, 100*time.Millisecond)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				s.Run(ctx)
+				close(done)
+			}()
+
+			s.Request("service")
+			time.Sleep(380 * time.Millisecond)
+			cancel()
+			<-done
+
+			if got := count(); got < tt.min || got > tt.max {
+				t.Errorf("重载次数 = %d, 期望 %d 到 %d", got, tt.min, tt.max)
+			}
+		})
+	}
+}
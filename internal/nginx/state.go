@@ -0,0 +1,63 @@
+package nginx
+
+// managerState 服务配置和容器注册信息的快照
+// 每次更新前记录，更新出错或配置测试失败时与配置文件一起恢复，保证定期同步能发现未生效的变更
+type managerState struct {
+	httpConfigs   map[string]*HTTPConfig
+	streamConfigs map[string]*StreamConfig
+	containers    map[string]*ContainerEntry
+}
+
+// copyState 复制当前的服务配置和容器注册信息
+func (m *Manager) copyState() managerState {
+	return managerState{
+		httpConfigs:   copyHTTPConfigs(m.httpConfigs),
+		streamConfigs: copyStreamConfigs(m.streamConfigs),
+		containers:    copyContainers(m.containers),
+	}
+}
+
+// restoreState 将服务配置和容器注册信息恢复为快照中的状态
+func (m *Manager) restoreState(state managerState) {
+	m.httpConfigs = state.httpConfigs
+	m.streamConfigs = state.streamConfigs
+	m.containers = state.containers
+}
+
+func copyHTTPConfigs(httpConfigs map[string]*HTTPConfig) map[string]*HTTPConfig {
+	copied := make(map[string]*HTTPConfig, len(httpConfigs))
+	for name, httpConfig := range httpConfigs {
+		copied[name] = copyHTTPConfig(httpConfig)
+	}
+	return copied
+}
+
+func copyHTTPConfig(httpConfig *HTTPConfig) *HTTPConfig {
+	c := *httpConfig
+	c.Upstream = append([]UpstreamServer(nil), httpConfig.Upstream...)
+	c.lastUpstream = append([]UpstreamServer(nil), httpConfig.lastUpstream...)
+	return &c
+}
+
+func copyStreamConfigs(streamConfigs map[string]*StreamConfig) map[string]*StreamConfig {
+	copied := make(map[string]*StreamConfig, len(streamConfigs))
+	for name, streamConfig := range streamConfigs {
+		copied[name] = copyStreamConfig(streamConfig)
+	}
+	return copied
+}
+
+func copyStreamConfig(streamConfig *StreamConfig) *StreamConfig {
+	c := *streamConfig
+	c.Upstream = append([]UpstreamServer(nil), streamConfig.Upstream...)
+	return &c
+}
+
+func copyContainers(containers map[string]*ContainerEntry) map[string]*ContainerEntry {
+	copied := make(map[string]*ContainerEntry, len(containers))
+	for containerID, entry := range containers {
+		e := *entry
+		copied[containerID] = &e
+	}
+	return copied
+}
@@ -0,0 +1,96 @@
+package nginx
+
+import (
+	"math"
+)
+
+// splitTraffic 按服务配置的各轨道流量百分比计算上游服务器的权重
+// 配置了百分比的轨道使用该百分比，其余轨道的服务器一起分配剩余的流量，没有服务器的轨道不参与分配
+// 流量为0的轨道的服务器标记为 down，backup 服务器和正在摘除流量的服务器保持不变
+// 返回的是上游服务器的副本，不修改已注册的上游服务器
+func splitTraffic(upstream []UpstreamServer, tracks map[string]int) []UpstreamServer {
+	if len(tracks) == 0 || len(upstream) == 0 {
+		return upstream
+	}
+
+	// 没有配置百分比的轨道分配的流量
+	rest := 100
+	for _, percent := range tracks {
+		rest -= percent
+	}
+	if rest < 0 {
+		rest = 0
+	}
+
+	// 各轨道的流量和服务器数量，未配置百分比的轨道合并为空字符串
+	shares := make(map[string]int)
+	counts := make(map[string]int)
+	for _, server := range upstream {
+		if server.Options.Backup || server.Down {
+			continue
+		}
+		group := trafficGroup(server.Options.Track, tracks)
+		counts[group]++
+		if percent, exists := tracks[group]; exists {
+			shares[group] = percent
+		} else {
+			shares[group] = rest
+		}
+	}
+	// 只有一个轨道有服务器时不需要分流
+	if len(counts) < 2 {
+		return upstream
+	}
+	total := 0
+	for _, share := range shares {
+		total += share
+	}
+	if total == 0 {
+		return upstream
+	}
+
+	// 每台服务器的权重为所在轨道的流量除以服务器数量，放大后取整再约分
+	weights := make(map[string]int)
+	divisor := 0
+	for group, share := range shares {
+		if share == 0 {
+			continue
+		}
+		weight := int(math.Round(float64(share) * 100 / float64(counts[group])))
+		if weight < 1 {
+			weight = 1
+		}
+		weights[group] = weight
+		divisor = gcd(divisor, weight)
+	}
+
+	servers := make([]UpstreamServer, len(upstream))
+	copy(servers, upstream)
+	for i := range servers {
+		if servers[i].Options.Backup || servers[i].Down {
+			continue
+		}
+		weight, exists := weights[trafficGroup(servers[i].Options.Track, tracks)]
+		if !exists {
+			servers[i].Down = true
+			continue
+		}
+		servers[i].Options.Weight = weight / divisor
+	}
+	return servers
+}
+
+// trafficGroup 获取服务器所在的分流组，未配置百分比的轨道属于同一组
+func trafficGroup(track string, tracks map[string]int) string {
+	if _, exists := tracks[track]; exists {
+		return track
+	}
+	return ""
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
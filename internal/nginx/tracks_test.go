@@ -0,0 +1,157 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// trackServers 按轨道创建上游服务器，backup 和 down 分别以 "+backup"、"+down" 后缀表示
+func trackServers(tracks ...string) []UpstreamServer {
+	servers := make([]UpstreamServer, 0, len(tracks))
+	for i, track := range tracks {
+		server := UpstreamServer{
+			ContainerID: fmt.Sprintf("c%d", i),
+			IP:          fmt.Sprintf("10.0.0.%d", i+1),
+			Port:        "80/tcp",
+		}
+		if name, found := strings.CutSuffix(track, "+backup"); found {
+			track = name
+			server.Options.Backup = true
+		}
+		if name, found := strings.CutSuffix(track, "+down"); found {
+			track = name
+			server.Down = true
+		}
+		server.Options.Track = track
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+func TestSplitTraffic(t *testing.T) {
+	tests := []struct {
+		name     string
+		servers  []string
+		tracks   map[string]int
+		weights  []int
+		downList []bool
+	}{
+		{
+			name:    "未配置tracks时不修改",
+			servers: []string{"stable", "canary"},
+			weights: []int{0, 0},
+		},
+		{
+			name:    "3个stable和1个canary按10%分流",
+			servers: []string{"stable", "stable", "stable", "canary"},
+			tracks:  map[string]int{"canary": 10},
+			weights: []int{3, 3, 3, 1},
+		},
+		{
+			name:    "每个轨道1个服务器时按百分比约分",
+			servers: []string{"stable", "canary"},
+			tracks:  map[string]int{"canary": 10},
+			weights: []int{9, 1},
+		},
+		{
+			name:    "流量均分时权重都为1",
+			servers: []string{"stable", "stable", "canary", "canary"},
+			tracks:  map[string]int{"canary": 50},
+			weights: []int{1, 1, 1, 1},
+		},
+		{
+			name:    "无法整除时四舍五入",
+			servers: []string{"canary", "canary", "canary", "stable", "stable", "stable", "stable", "stable", "stable", "stable"},
+			tracks:  map[string]int{"canary": 10},
+			weights: []int{333, 333, 333, 1286, 1286, 1286, 1286, 1286, 1286, 1286},
+		},
+		{
+			name:    "未列出的轨道分配剩余流量",
+			servers: []string{"stable", "canary", "beta"},
+			tracks:  map[string]int{"canary": 10, "beta": 20},
+			weights: []int{7, 1, 2},
+		},
+		{
+			name:    "百分比之和不足100且没有其他轨道时按比例分配",
+			servers: []string{"canary", "beta"},
+			tracks:  map[string]int{"canary": 10, "beta": 20},
+			weights: []int{1, 2},
+		},
+		{
+			name:    "只有一个轨道有服务器时不分流",
+			servers: []string{"stable", "stable"},
+			tracks:  map[string]int{"canary": 10},
+			weights: []int{0, 0},
+		},
+		{
+			name:    "没有服务器的轨道的流量由其他轨道分配",
+			servers: []string{"stable", "beta"},
+			tracks:  map[string]int{"canary": 10, "beta": 30},
+			weights: []int{2, 1},
+		},
+		{
+			name:     "流量为0的轨道标记为down",
+			servers:  []string{"blue", "green", "green"},
+			tracks:   map[string]int{"green": 100},
+			weights:  []int{0, 1, 1},
+			downList: []bool{true, false, false},
+		},
+		{
+			name:    "所有轨道流量都为0时不修改",
+			servers: []string{"stable", "canary"},
+			tracks:  map[string]int{"canary": 0, "stable": 0},
+			weights: []int{0, 0},
+		},
+		{
+			name:     "backup和正在摘除流量的服务器不参与分流",
+			servers:  []string{"stable", "stable+backup", "stable+down", "canary"},
+			tracks:   map[string]int{"canary": 25},
+			weights:  []int{3, 0, 0, 1},
+			downList: []bool{false, false, true, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := trackServers(tt.servers...)
+			got := splitTraffic(upstream, tt.tracks)
+
+			if len(got) != len(upstream) {
+				t.Fatalf("服务器数量 = %d, 期望 %d", len(got), len(upstream))
+			}
+			for i, server := range got {
+				if server.Options.Weight != tt.weights[i] {
+					t.Errorf("服务器 %d (%s) weight = %d, 期望 %d", i, tt.servers[i], server.Options.Weight, tt.weights[i])
+				}
+				wantDown := tt.downList != nil && tt.downList[i]
+				if server.Down != wantDown {
+					t.Errorf("服务器 %d (%s) down = %v, 期望 %v", i, tt.servers[i], server.Down, wantDown)
+				}
+			}
+			// 不修改已注册的上游服务器
+			for i, server := range upstream {
+				if server.Options.Weight != 0 {
+					t.Errorf("原服务器 %d 的 weight 被修改为 %d", i, server.Options.Weight)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitTrafficKeepsServerOptions(t *testing.T) {
+	maxFails := 2
+	upstream := trackServers("stable", "canary")
+	upstream[0].Options.MaxFails = &maxFails
+
+	got := splitTraffic(upstream, map[string]int{"canary": 10})
+	if got[0].Options.MaxFails == nil || *got[0].Options.MaxFails != 2 {
+		t.Errorf("max_fails 丢失: %+v", got[0].Options)
+	}
+	if got[0].Options.Track != "stable" || got[1].Options.Track != "canary" {
+		t.Errorf("track 被修改: %q, %q", got[0].Options.Track, got[1].Options.Track)
+	}
+	if params := got[1].Params(); params != " weight=1" {
+		t.Errorf("Params() = %q, 期望 %q", params, " weight=1")
+	}
+}
@@ -0,0 +1,95 @@
+package watcher
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// 触发摘除流量的信号，docker stop 默认先发送 SIGTERM，超时后发送 SIGKILL
+// 其他信号（如用于重新加载配置的 SIGHUP）不会使容器停止
+var drainSignals = map[string]bool{
+	"2":    true,
+	"3":    true,
+	"9":    true,
+	"15":   true,
+	"INT":  true,
+	"QUIT": true,
+	"KILL": true,
+	"TERM": true,
+}
+
+// handleContainerKill 处理容器收到信号的事件
+// 配置了 drain_period 时先将容器标记为 down 并重载nginx，等待期结束后再移除上游服务器
+func (w *Watcher) handleContainerKill(containerID string, signal string) {
+	if !drainSignals[strings.TrimPrefix(strings.ToUpper(signal), "SIG")] {
+		return
+	}
+	if w.isDraining(containerID) {
+		return
+	}
+	if _, exists := w.nginxMgr.GetContainer(containerID); !exists {
+		return
+	}
+
+	period := w.drainPeriod(containerID)
+	if period <= 0 {
+		// 未启用摘除流量，由随后的 die 和 stop 事件移除
+		return
+	}
+
+	serviceName, err := w.nginxMgr.DrainContainer(containerID)
+	if err != nil {
+		log.Printf("警告: 摘除容器 %s 的流量失败，立即移除 [服务: %s]: %v", containerID, serviceName, err)
+		w.removeContainer(containerID)
+		return
+	}
+	w.reloader.Request(serviceName)
+	log.Printf("处理: 容器 %s 正在停止，已标记为 down，%s 后从服务 %s 中移除", containerID, period, serviceName)
+
+	// 回调需要获取 syncMutex，当前处理事件时已持有，保证 timer 赋值后回调才会执行
+	var timer *time.Timer
+	timer = time.AfterFunc(period, func() { w.finishDrain(containerID, timer) })
+	w.draining[containerID] = timer
+}
+
+// finishDrain 等待期结束后移除容器的上游服务器
+func (w *Watcher) finishDrain(containerID string, timer *time.Timer) {
+	w.syncMutex.Lock()
+	defer w.syncMutex.Unlock()
+
+	// 容器已重新启动或已被移除
+	if w.draining[containerID] != timer {
+		return
+	}
+	delete(w.draining, containerID)
+
+	log.Printf("处理: 容器 %s 摘除流量结束，移除上游服务器", containerID)
+	w.removeContainer(containerID)
+}
+
+// cancelDrain 取消容器的摘除流量，如容器在等待期内重新启动
+func (w *Watcher) cancelDrain(containerID string) {
+	timer, exists := w.draining[containerID]
+	if !exists {
+		return
+	}
+	timer.Stop()
+	delete(w.draining, containerID)
+	log.Printf("处理: 容器 %s 在摘除流量期间重新启动，取消摘除", containerID)
+}
+
+// isDraining 容器是否正在摘除流量
+func (w *Watcher) isDraining(containerID string) bool {
+	_, exists := w.draining[containerID]
+	return exists
+}
+
+// drainPeriod 获取容器所属服务的摘除流量等待时间
+func (w *Watcher) drainPeriod(containerID string) time.Duration {
+	container, err := w.getContainerInfo(containerID)
+	if err != nil {
+		return w.config.DrainPeriod(nil)
+	}
+	return w.config.DrainPeriod(w.matchService(container))
+}
@@ -0,0 +1,45 @@
+package watcher
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"docker-tool/internal/config"
+)
+
+// checkReadiness 检查容器是否可以加入上游服务器
+// 容器配置了HEALTHCHECK时等待其变为健康，否则等待服务配置的宽限期结束
+// 未就绪时返回原因，以及需要多久之后重新检查（为0时等待健康检查事件）
+func (w *Watcher) checkReadiness(service *config.ServiceConfig, container *types.ContainerJSON) (bool, time.Duration, string) {
+	state := container.State
+	if state == nil {
+		return true, 0, ""
+	}
+	if !state.Running || state.Paused {
+		return false, 0, "容器未在运行"
+	}
+
+	if state.Health != nil && state.Health.Status != types.NoHealthcheck {
+		if !service.RequiresHealthy() {
+			return true, 0, ""
+		}
+		if state.Health.Status != types.Healthy {
+			return false, 0, fmt.Sprintf("健康状态为 %s", state.Health.Status)
+		}
+		return true, 0, ""
+	}
+
+	if service.HealthGracePeriod <= 0 {
+		return true, 0, ""
+	}
+	startedAt, err := time.Parse(time.RFC3339Nano, state.StartedAt)
+	if err != nil {
+		return true, 0, ""
+	}
+	if remaining := service.HealthGracePeriod - time.Since(startedAt); remaining > 0 {
+		return false, remaining, fmt.Sprintf("容器没有健康检查，等待宽限期 %s", remaining.Round(time.Second))
+	}
+	return true, 0, ""
+}
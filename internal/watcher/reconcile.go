@@ -0,0 +1,141 @@
+package watcher
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/go-connections/nat"
+
+	"docker-tool/internal/config"
+)
+
+// 默认的定期同步间隔
+const defaultReconcileInterval = 60 * time.Second
+
+// desiredUpstream 容器期望注册的上游服务器
+type desiredUpstream struct {
+	service *config.ServiceConfig
+	ips     []string
+	port    nat.Port
+	options config.ServerOptions
+}
+
+// reconcileLoop 定期将nginx配置与运行中的容器同步，修正遗漏事件导致的偏差
+func (w *Watcher) reconcileLoop(ctx context.Context) {
+	w.syncMutex.Lock()
+	interval := w.config.Global.ReconcileInterval
+	w.syncMutex.Unlock()
+	if interval <= 0 {
+		interval = defaultReconcileInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.reconcile(ctx)
+		}
+	}
+}
+
+// reconcile 计算每个服务期望的上游服务器，与已注册的容器对比后只应用差异
+func (w *Watcher) reconcile(ctx context.Context) {
+	containers, err := w.client.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		log.Printf("警告: 定期同步获取容器列表失败: %v", err)
+		return
+	}
+
+	w.syncMutex.Lock()
+	defer w.syncMutex.Unlock()
+
+	desired := make(map[string]desiredUpstream)
+	inspected := make(map[string]*types.ContainerJSON)
+	for _, summary := range containers {
+		container, err := w.getContainerInfo(summary.ID)
+		if err != nil {
+			continue
+		}
+		service := w.matchService(container)
+		if service == nil {
+			continue
+		}
+		if err := w.config.ValidateService(service); err != nil {
+			continue
+		}
+		// 未就绪的容器由健康检查事件或宽限期结束后的重新检查处理
+		if ready, _, _ := w.checkReadiness(service, container); !ready {
+			continue
+		}
+		target, err := w.resolveUpstream(service, container)
+		if err != nil {
+			continue
+		}
+		desired[container.ID] = desiredUpstream{service: service, ips: target.addresses, port: target.port, options: target.options}
+		inspected[container.ID] = container
+	}
+
+	actual := w.nginxMgr.Containers()
+	drifts := 0
+
+	// 缺失或信息已变化的容器
+	for containerID, want := range desired {
+		have, exists := actual[containerID]
+		sameAddress := exists && have.ServiceName == want.service.Name && slices.Equal(have.IPs, want.ips) && have.Port == want.port
+		// 没有在摘除流量的容器不应被标记为 down
+		staleDown := exists && have.Down && !w.isDraining(containerID)
+		if sameAddress && have.Options.Equal(want.options) && !staleDown {
+			continue
+		}
+
+		switch {
+		case staleDown:
+			log.Printf("同步: 容器 %s 没有在摘除流量，但上游服务器被标记为 down [服务: %s]", inspected[containerID].Name, have.ServiceName)
+		case sameAddress:
+			log.Printf("同步: 容器 %s 的上游服务器参数已变化 [服务: %s]", inspected[containerID].Name, have.ServiceName)
+		case exists:
+			log.Printf("同步: 容器 %s 的上游服务器已变化 [服务: %s, %s -> 服务: %s, %s 端口 %s]",
+				inspected[containerID].Name, have.ServiceName, have.Addresses(), want.service.Name, strings.Join(want.ips, ", "), want.port.Port())
+		default:
+			log.Printf("同步: 容器 %s 未注册到服务 %s，添加上游服务器 %s 端口 %s", inspected[containerID].Name, want.service.Name, strings.Join(want.ips, ", "), want.port.Port())
+		}
+		if err := w.nginxMgr.UpdateService(want.service, containerID, want.ips, want.port, want.options); err != nil {
+			log.Printf("警告: 同步更新nginx配置失败 [服务: %s]: %v", want.service.Name, err)
+			continue
+		}
+		w.reloader.Request(want.service.Name)
+		drifts++
+	}
+
+	// 已不存在或不再匹配服务的容器
+	for containerID, have := range actual {
+		if _, exists := desired[containerID]; exists {
+			continue
+		}
+		// 正在摘除流量的容器在等待期结束后移除
+		if w.isDraining(containerID) {
+			continue
+		}
+
+		log.Printf("同步: 容器 %s 已不再运行，移除服务 %s 的上游服务器 %s", containerID, have.ServiceName, have.Addresses())
+		if _, err := w.nginxMgr.RemoveContainer(containerID); err != nil {
+			log.Printf("警告: 同步移除上游服务器失败 [服务: %s]: %v", have.ServiceName, err)
+			continue
+		}
+		w.reloader.Request(have.ServiceName)
+		drifts++
+	}
+
+	if drifts > 0 {
+		w.driftCorrected += int64(drifts)
+		log.Printf("同步完成: 本次修正 %d 处偏差，累计修正 %d 处", drifts, w.driftCorrected)
+	}
+}
@@ -4,11 +4,18 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
 
@@ -21,8 +28,28 @@ type Watcher struct {
 	client   *client.Client
 	config   *config.Config
 	nginxMgr *nginx.Manager
+	reloader *nginx.ReloadScheduler
+	// 保证事件处理和定期同步不会同时修改nginx配置
+	syncMutex sync.Mutex
+	// 定期同步修正的偏差总数
+	driftCorrected int64
+	// 最后处理的Docker事件时间，事件流重连时从这里继续
+	lastEventTime time.Time
+	// 事件流断开的时间
+	disconnectedAt time.Time
+	// 正在摘除流量的容器，等待期结束后移除
+	draining map[string]*time.Timer
 }
 
+// 事件流重连的退避时间
+const (
+	reconnectBaseDelay = 1 * time.Second
+	reconnectMaxDelay  = 30 * time.Second
+)
+
+// 事件流断开超过该时间时，认为无法通过 since 补齐遗漏的事件
+const maxEventGap = time.Minute
+
 // New 创建新的容器监听器
 func New(cfg *config.Config) (*Watcher, error) {
 	// 创建Docker客户端
@@ -32,12 +59,17 @@ func New(cfg *config.Config) (*Watcher, error) {
 	}
 
 	// 创建nginx管理器
-	nginxMgr := nginx.NewManager(cfg)
+	nginxMgr := nginx.NewManager(cfg, dockerClient)
+
+	// 创建nginx重载调度器
+	reloader := nginx.NewReloadScheduler(nginxMgr, cfg.Global.ReloadDebounce, cfg.Global.ReloadMaxDelay)
 
 	return &Watcher{
 		client:   dockerClient,
 		config:   cfg,
 		nginxMgr: nginxMgr,
+		reloader: reloader,
+		draining: make(map[string]*time.Timer),
 	}, nil
 }
 
@@ -45,6 +77,21 @@ func New(cfg *config.Config) (*Watcher, error) {
 func (w *Watcher) Start(ctx context.Context) error {
 	log.Println("开始监听Docker容器事件...")
 
+	// 从启动时开始接收事件，启动前的状态由检查现有容器处理
+	w.lastEventTime = time.Now()
+
+	// 旧版本按服务生成的HTTP配置文件没有标记，不会被自动清理
+	var httpServices []string
+	for _, service := range w.config.Services {
+		if service.Type == "http" {
+			httpServices = append(httpServices, service.Name)
+		}
+	}
+	w.nginxMgr.WarnLegacyHTTPFiles(httpServices)
+
+	// 启动nginx重载调度
+	go w.reloader.Run(ctx)
+
 	// 启动事件监听
 	go w.listenEvents(ctx)
 
@@ -54,6 +101,9 @@ func (w *Watcher) Start(ctx context.Context) error {
 	// 启动时检查所有现有容器
 	go w.checkExistingContainers(ctx)
 
+	// 启动定期同步
+	go w.reconcileLoop(ctx)
+
 	return nil
 }
 
@@ -65,114 +115,252 @@ func (w *Watcher) Stop() error {
 	return nil
 }
 
-// listenEvents 监听Docker事件
+// listenEvents 监听Docker事件，事件流出错时按指数退避重连
 func (w *Watcher) listenEvents(ctx context.Context) {
+	attempt := 0
 	for {
+		received, err := w.startEventStream(ctx)
+		if ctx.Err() != nil {
+			log.Println("停止监听Docker事件")
+			return
+		}
+		if received {
+			// 事件流曾正常工作，重新开始计算退避时间
+			attempt = 0
+		}
+
+		delay := reconnectBackoff(attempt)
+		attempt++
+		log.Printf("Docker事件流错误: %v，%s 后重连（第 %d 次）", err, delay.Round(time.Millisecond), attempt)
+
 		select {
 		case <-ctx.Done():
 			log.Println("停止监听Docker事件")
 			return
-		default:
-			w.startEventStream(ctx)
+		case <-time.After(delay):
 		}
 	}
 }
 
-// startEventStream 启动事件流
-func (w *Watcher) startEventStream(ctx context.Context) {
+// reconnectBackoff 计算第 attempt 次重连前的等待时间，带随机抖动避免集中重连
+func reconnectBackoff(attempt int) time.Duration {
+	delay := reconnectBaseDelay
+	for i := 0; i < attempt && delay < reconnectMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > reconnectMaxDelay {
+		delay = reconnectMaxDelay
+	}
+	// 在 [delay/2, delay) 之间随机取值
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// startEventStream 启动事件流，从上次处理的事件时间开始接收，避免重连期间遗漏事件
+// 返回本次是否收到过事件以及导致事件流结束的错误
+func (w *Watcher) startEventStream(ctx context.Context) (bool, error) {
 	// 设置事件过滤器
 	eventFilters := filters.NewArgs()
 	eventFilters.Add("type", "container")
+	eventFilters.Add("type", "network")
 	eventFilters.Add("event", "start")
+	// docker stop 会先发送信号，用于在容器退出前摘除流量
+	eventFilters.Add("event", "kill")
 	eventFilters.Add("event", "stop")
 	eventFilters.Add("event", "die")
+	eventFilters.Add("event", "destroy")
 	eventFilters.Add("event", "rename")
+	eventFilters.Add("event", "pause")
+	eventFilters.Add("event", "unpause")
+	eventFilters.Add("event", "health_status")
+	// 容器连接或断开网络时IP会变化
+	eventFilters.Add("event", "connect")
+	eventFilters.Add("event", "disconnect")
 
 	// 创建事件选项
 	eventOptions := types.EventsOptions{
 		Filters: eventFilters,
+		Since:   formatEventTime(w.lastEventTime),
 	}
 
+	// 断开时间过长时，Docker只保留有限数量的历史事件，无法保证补齐，需要全量同步
+	needReconcile := !w.disconnectedAt.IsZero() && time.Since(w.disconnectedAt) > maxEventGap
+
 	// 启动事件流
 	eventStream, errStream := w.client.Events(ctx, eventOptions)
+	if !w.disconnectedAt.IsZero() {
+		log.Printf("Docker事件流已重连，从 %s 开始补齐事件", w.lastEventTime.Format(time.RFC3339Nano))
+	}
+	if needReconcile {
+		log.Printf("Docker事件流断开超过 %s，执行全量同步", maxEventGap)
+		go w.reconcile(ctx)
+	}
+	w.disconnectedAt = time.Time{}
 
+	received := false
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return received, ctx.Err()
 		case event := <-eventStream:
+			received = true
 			w.handleEvent(event)
+			// 记录最后处理的事件时间，重连时从这里继续
+			if event.TimeNano > 0 {
+				w.lastEventTime = time.Unix(0, event.TimeNano)
+			}
 		case err := <-errStream:
-			log.Printf("Docker事件流错误: %v", err)
-			// 等待一段时间后重连
-			time.Sleep(5 * time.Second)
-			return
+			w.disconnectedAt = time.Now()
+			return received, err
 		}
 	}
 }
 
+// formatEventTime 将时间格式化为Docker事件API的 since 参数
+func formatEventTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
 // handleEvent 处理Docker事件
 func (w *Watcher) handleEvent(event events.Message) {
 	log.Printf("收到Docker事件: %s %s", event.Action, event.Actor.ID)
 
+	w.syncMutex.Lock()
+	defer w.syncMutex.Unlock()
+
+	// 网络事件的 Actor 为网络，容器ID在属性中
+	if event.Type == events.NetworkEventType {
+		containerID := event.Actor.Attributes["container"]
+		if containerID == "" {
+			return
+		}
+		switch event.Action {
+		case "connect", "disconnect":
+			w.handleContainerNetworkChange(containerID)
+		}
+		return
+	}
+
+	// 健康检查事件的 Action 形如 "health_status: healthy"
+	if strings.HasPrefix(string(event.Action), "health_status") {
+		w.handleContainerStart(event.Actor.ID)
+		return
+	}
+
 	switch event.Action {
-	case "start":
+	case "start", "unpause":
+		w.cancelDrain(event.Actor.ID)
 		w.handleContainerStart(event.Actor.ID)
+	case "kill":
+		w.handleContainerKill(event.Actor.ID, event.Actor.Attributes["signal"])
 	case "stop", "die":
 		w.handleContainerStop(event.Actor.ID)
+	case "destroy":
+		// 容器已被删除，无需继续等待
+		w.cancelDrain(event.Actor.ID)
+		w.handleContainerStop(event.Actor.ID)
+	case "pause":
+		w.handleContainerPause(event.Actor.ID)
 	case "rename":
 		w.handleContainerRename(event.Actor.ID)
 	}
 }
 
-// handleContainerStart 处理容器启动事件
-func (w *Watcher) handleContainerStart(containerID string) {
+// handleContainerStart 处理容器启动事件，返回容器匹配到的服务配置
+func (w *Watcher) handleContainerStart(containerID string) *config.ServiceConfig {
 	container, err := w.getContainerInfo(containerID)
 	if err != nil {
 		log.Printf("警告: 获取容器信息失败 %s: %v", containerID, err)
-		return
+		return nil
 	}
 
 	// 检查是否匹配配置中的服务
-	service := w.config.GetServiceByContainerName(container.Name)
+	service := w.matchService(container)
 	if service == nil {
 		// 降低日志级别，避免日志过多
 		log.Printf("信息: 容器 %s 未匹配到任何服务配置", container.Name)
-		return
+		return nil
 	}
 
 	// 验证服务配置
 	if err := w.config.ValidateService(service); err != nil {
 		log.Printf("警告: 服务 %s 配置无效，跳过处理: %v", service.Name, err)
-		return
+		return nil
+	}
+
+	// 正在停止的容器仍可能产生健康检查和网络事件，不能重新加入上游服务器
+	if w.isDraining(containerID) {
+		return service
+	}
+
+	// 检查容器是否已可以接收流量
+	if ready, wait, reason := w.checkReadiness(service, container); !ready {
+		log.Printf("信息: 容器 %s 暂不加入服务 %s: %s", container.Name, service.Name, reason)
+		if _, exists := w.nginxMgr.GetContainer(containerID); exists {
+			w.removeContainer(containerID)
+		}
+		if wait > 0 {
+			time.AfterFunc(wait, func() { w.recheckContainer(containerID) })
+		}
+		return service
 	}
 
 	log.Printf("处理: 容器 %s 启动，更新nginx配置", container.Name)
 	w.updateNginxConfig(service, container)
+	return service
+}
+
+// recheckContainer 等待期结束后重新检查容器
+func (w *Watcher) recheckContainer(containerID string) {
+	w.syncMutex.Lock()
+	defer w.syncMutex.Unlock()
+
+	w.handleContainerStart(containerID)
 }
 
 // handleContainerStop 处理容器停止事件
 func (w *Watcher) handleContainerStop(containerID string) {
-	container, err := w.getContainerInfo(containerID)
-	if err != nil {
-		log.Printf("警告: 获取容器信息失败 %s: %v", containerID, err)
+	// 根据注册表移除容器贡献的上游服务器，容器被销毁后无法再获取其信息
+	entry, exists := w.nginxMgr.GetContainer(containerID)
+	if !exists {
+		return
+	}
+	// 正在摘除流量的容器在等待期结束后移除
+	if w.isDraining(containerID) {
 		return
 	}
 
-	// 检查是否匹配配置中的服务
-	service := w.config.GetServiceByContainerName(container.Name)
-	if service == nil {
+	log.Printf("处理: 容器 %s 停止，移除服务 %s 的上游服务器 %s", containerID, entry.ServiceName, entry.Addresses())
+	w.removeContainer(containerID)
+}
+
+// handleContainerPause 处理容器暂停事件，暂停的容器无法处理请求
+func (w *Watcher) handleContainerPause(containerID string) {
+	entry, exists := w.nginxMgr.GetContainer(containerID)
+	if !exists {
 		return
 	}
 
-	// 验证服务配置
-	if err := w.config.ValidateService(service); err != nil {
-		log.Printf("警告: 服务 %s 配置无效，跳过处理: %v", service.Name, err)
+	log.Printf("处理: 容器 %s 暂停，移除服务 %s 的上游服务器 %s", containerID, entry.ServiceName, entry.Addresses())
+	w.removeContainer(containerID)
+}
+
+// handleContainerNetworkChange 处理容器连接或断开网络事件，重新计算容器地址
+func (w *Watcher) handleContainerNetworkChange(containerID string) {
+	container, err := w.getContainerInfo(containerID)
+	if err != nil {
+		// 容器已被删除时网络断开事件会晚于容器事件到达
+		return
+	}
+	if container.State != nil && !container.State.Running {
 		return
 	}
 
-	log.Printf("处理: 容器 %s 停止，更新nginx配置", container.Name)
-	w.updateNginxConfig(service, nil)
+	log.Printf("处理: 容器 %s 网络变化，重新计算上游服务器地址", container.Name)
+	w.handleContainerStart(containerID)
 }
 
 // handleContainerRename 处理容器重命名事件
@@ -184,8 +372,13 @@ func (w *Watcher) handleContainerRename(containerID string) {
 	}
 
 	// 检查是否匹配配置中的服务
-	service := w.config.GetServiceByContainerName(container.Name)
+	service := w.matchService(container)
 	if service == nil {
+		// 重命名后不再匹配任何服务，移除之前注册的上游服务器
+		if _, exists := w.nginxMgr.GetContainer(containerID); exists {
+			log.Printf("处理: 容器 %s 重命名后未匹配到任何服务配置，移除上游服务器", container.Name)
+			w.removeContainer(containerID)
+		}
 		return
 	}
 
@@ -199,6 +392,26 @@ func (w *Watcher) handleContainerRename(containerID string) {
 	w.updateNginxConfig(service, container)
 }
 
+// matchService 查找容器对应的服务配置
+// 优先使用配置文件中的服务，未匹配时根据容器标签生成服务配置
+func (w *Watcher) matchService(container *types.ContainerJSON) *config.ServiceConfig {
+	var labels map[string]string
+	if container.Config != nil {
+		labels = container.Config.Labels
+	}
+
+	if service := w.config.GetServiceByContainer(container.Name, labels); service != nil {
+		return service
+	}
+
+	service, err := w.config.ServiceFromLabels(container.Name, labels)
+	if err != nil {
+		log.Printf("警告: 容器 %s 的标签配置无效，跳过处理: %v", container.Name, err)
+		return nil
+	}
+	return service
+}
+
 // checkExistingContainers 检查现有容器
 func (w *Watcher) checkExistingContainers(ctx context.Context) {
 	time.Sleep(2 * time.Second) // 等待Docker daemon准备就绪
@@ -214,30 +427,80 @@ func (w *Watcher) checkExistingContainers(ctx context.Context) {
 	}
 
 	processedCount := 0
+	// 当前有效的服务，包括配置文件中的服务和容器标签声明的服务
+	w.syncMutex.Lock()
+	activeServices := w.configuredServices()
+	w.syncMutex.Unlock()
 
 	for _, container := range containers {
 		if container.State == "running" {
-			// 使用goroutine处理每个容器，避免一个容器出错影响其他容器
-			go func(containerID string) {
-				defer func() {
-					if r := recover(); r != nil {
-						log.Printf("警告: 处理容器 %s 时发生panic: %v", containerID, r)
-					}
-				}()
-				w.handleContainerStart(containerID)
-			}(container.ID)
+			// 重载由调度器合并，逐个处理容器即可
+			if service := w.checkContainer(container.ID); service != nil {
+				activeServices[service.Name] = service.Type
+			}
 			processedCount++
 		}
 	}
 
 	log.Printf("已处理 %d 个运行中的容器", processedCount)
-	
+
 	// 处理SNI配置（不依赖容器）
 	w.processSNIServices()
+
+	// 没有运行中容器的服务生成维护页面
+	w.processMaintenanceServices()
+
+	// 清理不再存在的服务的配置
+	w.cleanupOrphans(activeServices)
+}
+
+// checkContainer 处理单个现有容器，避免一个容器出错影响其他容器
+func (w *Watcher) checkContainer(containerID string) (service *config.ServiceConfig) {
+	w.syncMutex.Lock()
+	defer w.syncMutex.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("警告: 处理容器 %s 时发生panic: %v", containerID, r)
+			service = nil
+		}
+	}()
+	return w.handleContainerStart(containerID)
+}
+
+// configuredServices 获取配置文件中的有效服务名称到服务类型的映射，调用时需要持有 syncMutex
+func (w *Watcher) configuredServices() map[string]string {
+	services := make(map[string]string)
+	for _, service := range w.config.Services {
+		if err := w.config.ValidateService(&service); err != nil {
+			continue
+		}
+		services[service.Name] = service.Type
+	}
+	return services
+}
+
+// cleanupOrphans 清理不再存在的服务的配置并重载nginx
+func (w *Watcher) cleanupOrphans(activeServices map[string]string) {
+	removed, err := w.nginxMgr.CleanupOrphans(activeServices)
+	if err != nil {
+		log.Printf("警告: 清理孤立配置失败: %v", err)
+	}
+	if len(removed) == 0 {
+		return
+	}
+
+	log.Printf("已清理 %d 个孤立配置: %s", len(removed), strings.Join(removed, ", "))
+	for _, serviceName := range removed {
+		w.reloader.Request(serviceName)
+	}
 }
 
 // processSNIServices 处理SNI服务配置（不依赖容器）
 func (w *Watcher) processSNIServices() {
+	w.syncMutex.Lock()
+	defer w.syncMutex.Unlock()
+
 	log.Println("处理SNI服务配置...")
 	
 	for _, service := range w.config.Services {
@@ -253,7 +516,7 @@ func (w *Watcher) processSNIServices() {
 			
 			// 为SNI服务生成配置（传递空的容器信息）
 			port, _ := nat.NewPort("tcp", fmt.Sprintf("%d", service.ContainerPort))
-			if err := w.nginxMgr.UpdateService(&service, "", port); err != nil {
+			if err := w.nginxMgr.UpdateService(&service, "", nil, port, config.ServerOptions{}); err != nil {
 				log.Printf("警告: 生成SNI服务 %s 配置失败: %v", service.Name, err)
 			} else {
 				log.Printf("成功: 已生成SNI服务 %s 的配置", service.Name)
@@ -262,6 +525,40 @@ func (w *Watcher) processSNIServices() {
 	}
 }
 
+// processMaintenanceServices 处理没有运行中容器的HTTP服务
+// on_empty 为 maintenance 的服务生成维护页面配置，已有配置的服务按重新加载后的 on_empty 重新生成
+func (w *Watcher) processMaintenanceServices() {
+	w.syncMutex.Lock()
+	defer w.syncMutex.Unlock()
+
+	registered := make(map[string]bool)
+	for _, entry := range w.nginxMgr.Containers() {
+		registered[entry.ServiceName] = true
+	}
+
+	for _, service := range w.config.Services {
+		if service.Type != "http" || registered[service.Name] {
+			continue
+		}
+		exists := w.nginxMgr.HasService(service.Name)
+		if !exists && w.config.OnEmpty(&service) != config.OnEmptyMaintenance {
+			continue
+		}
+		if err := w.config.ValidateService(&service); err != nil {
+			continue
+		}
+
+		if err := w.nginxMgr.UpdateService(&service, "", nil, "", config.ServerOptions{}); err != nil {
+			log.Printf("警告: 生成服务 %s 的维护页面配置失败: %v", service.Name, err)
+			continue
+		}
+		if !exists {
+			log.Printf("处理: 服务 %s 没有运行中的容器，已生成维护页面配置", service.Name)
+		}
+		w.reloader.Request(service.Name)
+	}
+}
+
 // getContainerInfo 获取容器详细信息
 func (w *Watcher) getContainerInfo(containerID string) (*types.ContainerJSON, error) {
 	container, err := w.client.ContainerInspect(context.Background(), containerID)
@@ -274,117 +571,413 @@ func (w *Watcher) getContainerInfo(containerID string) (*types.ContainerJSON, er
 // updateNginxConfig 更新nginx配置
 func (w *Watcher) updateNginxConfig(service *config.ServiceConfig, container *types.ContainerJSON) {
 	// 获取容器IP和端口
-	var containerIP string
-	var containerPort nat.Port
-
-	if container != nil {
-		containerIP = w.getContainerIP(container)
-		containerPort = w.getContainerPort(container, service)
-
-		// 检查IP和端口是否有效
-		if containerIP == "" {
-			log.Printf("警告: 服务 %s 无法获取容器IP，跳过配置更新", service.Name)
-			return
-		}
-		if containerPort == "" {
-			log.Printf("警告: 服务 %s 无法获取容器端口，跳过配置更新", service.Name)
-			return
+	target, err := w.resolveUpstream(service, container)
+	if err != nil {
+		log.Printf("警告: 服务 %s %v，跳过配置更新", service.Name, err)
+		// 容器之前注册的地址已失效（如断开了网络），移除上游服务器
+		if _, exists := w.nginxMgr.GetContainer(container.ID); exists {
+			w.removeContainer(container.ID)
 		}
+		return
+	}
+
+	if target.binding != "" {
+		log.Printf("信息: 容器 %s 使用网络 %s，上游服务器地址 %s 端口 %s（端口映射 %s）", container.Name, target.network, strings.Join(target.addresses, ", "), target.port.Port(), target.binding)
+	} else {
+		log.Printf("信息: 容器 %s 使用网络 %s，上游服务器地址 %s 端口 %s", container.Name, target.network, strings.Join(target.addresses, ", "), target.port.Port())
 	}
 
 	// 更新nginx配置
-	if err := w.nginxMgr.UpdateService(service, containerIP, containerPort); err != nil {
+	if err := w.nginxMgr.UpdateService(service, container.ID, target.addresses, target.port, target.options); err != nil {
 		log.Printf("警告: 更新nginx配置失败 [服务: %s]: %v", service.Name, err)
 		return
 	}
 
-	// 重载nginx
-	if err := w.nginxMgr.Reload(); err != nil {
-		log.Printf("警告: 重载nginx失败 [服务: %s]: %v", service.Name, err)
+	// 请求重载nginx，短时间内的多次变更会合并为一次重载
+	w.reloader.Request(service.Name)
+
+	log.Printf("成功: 服务 %s 的nginx配置已更新，等待重载", service.Name)
+}
+
+// upstreamTarget 容器作为上游服务器的地址
+type upstreamTarget struct {
+	addresses []string
+	port      nat.Port
+	// 选用的网络
+	network string
+	// 使用的宿主机端口映射，如 0.0.0.0:8080->80/tcp，直接访问容器时为空
+	binding string
+	// 上游服务器参数，如 weight、max_fails
+	options config.ServerOptions
+}
+
+// resolveUpstream 获取容器作为上游服务器的地址和端口
+// 地址按服务的 upstream_address_mode 决定，未配置时host和bridge网络使用宿主机IP和端口映射，其他网络使用容器IP
+func (w *Watcher) resolveUpstream(service *config.ServiceConfig, container *types.ContainerJSON) (*upstreamTarget, error) {
+	networkName, err := w.selectNetwork(container, service)
+	if err != nil {
+		return nil, err
+	}
+
+	target := &upstreamTarget{network: networkName}
+	// 是否使用发布到宿主机的端口
+	usePublished := false
+	switch w.config.AddressMode(service) {
+	case config.AddressModeIP:
+		target.addresses = w.networkIPs(container, service, networkName, false)
+	case config.AddressModeDNS:
+		if err := w.checkDNSNetwork(networkName); err != nil {
+			return nil, err
+		}
+		target.addresses = []string{strings.TrimPrefix(container.Name, "/")}
+	case config.AddressModeHostPort:
+		target.addresses = w.hostIPs(service.IPFamily)
+		usePublished = networkName != "host"
+	default:
+		target.addresses = w.networkIPs(container, service, networkName, true)
+		usePublished = networkName == "bridge"
+	}
+	target.port, target.binding, err = w.getContainerPort(container, service, usePublished)
+	if err != nil {
+		return nil, err
+	}
+	var labels map[string]string
+	if container.Config != nil {
+		labels = container.Config.Labels
+	}
+	target.options, err = service.ServerOptionsFromLabels(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	// 检查地址和端口是否有效
+	if len(target.addresses) == 0 {
+		return nil, fmt.Errorf("无法获取容器IP")
+	}
+	if target.port == "" {
+		return nil, fmt.Errorf("无法获取容器端口")
+	}
+	return target, nil
+}
+
+// removeContainer 移除容器贡献的上游服务器并重载nginx
+func (w *Watcher) removeContainer(containerID string) {
+	serviceName, err := w.nginxMgr.RemoveContainer(containerID)
+	if err != nil {
+		log.Printf("警告: 移除容器 %s 的上游服务器失败 [服务: %s]: %v", containerID, serviceName, err)
 		return
 	}
+	if serviceName == "" {
+		return
+	}
+
+	// 请求重载nginx
+	w.reloader.Request(serviceName)
 
-	log.Printf("成功: 服务 %s 的nginx配置已更新并重载", service.Name)
+	log.Printf("成功: 服务 %s 已移除容器 %s，等待重载", serviceName, containerID)
 }
 
-// getContainerIP 获取容器IP地址
-func (w *Watcher) getContainerIP(container *types.ContainerJSON) string {
+// selectNetwork 选择容器作为上游服务器使用的网络
+// 服务配置了 network（或全局配置了 default_network）时按优先级选择网络，
+// 所有指定的网络都未连接到容器时返回错误；否则按网络名称排序选择，保证每次结果一致
+func (w *Watcher) selectNetwork(container *types.ContainerJSON, service *config.ServiceConfig) (string, error) {
+	networks := container.NetworkSettings.Networks
+
 	// 检查是否是host网络模式
-	if _, exists := container.NetworkSettings.Networks["host"]; exists {
-		// host网络模式，返回宿主机IP
-		return w.config.Global.HostIP
+	if _, exists := networks["host"]; exists {
+		return "host", nil
+	}
+
+	// 按配置的优先级选择网络
+	if preferred := w.preferredNetworks(service); len(preferred) > 0 {
+		for _, networkName := range preferred {
+			endpoint, exists := networks[networkName]
+			if !exists {
+				continue
+			}
+			if networkName == "bridge" {
+				return networkName, nil
+			}
+			if ips := endpointIPs(endpoint, service.IPFamily); len(ips) > 0 {
+				return networkName, nil
+			}
+		}
+		return "", fmt.Errorf("容器 %s 未连接到指定的网络 %v，或网络中没有 %s 地址（已连接: %v）",
+			container.Name, preferred, ipFamilyName(service.IPFamily), sortedNetworkNames(container))
 	}
 
-	// 优先获取macvlan网络的IP
-	for networkName, network := range container.NetworkSettings.Networks {
-		if networkName != "bridge" && network.IPAddress != "" {
-			return network.IPAddress
+	// 优先选择macvlan等非bridge网络，按名称排序保证结果确定
+	for _, networkName := range sortedNetworkNames(container) {
+		if networkName == "bridge" {
+			continue
+		}
+		if ips := endpointIPs(networks[networkName], service.IPFamily); len(ips) > 0 {
+			return networkName, nil
 		}
 	}
 
-	// 对于bridge网络，返回宿主机IP（使用宿主机端口映射）
-	if _, exists := container.NetworkSettings.Networks["bridge"]; exists {
-		return w.config.Global.HostIP
+	if _, exists := networks["bridge"]; exists {
+		return "bridge", nil
 	}
 
-	return ""
+	return "", fmt.Errorf("容器 %s 没有可用的 %s 地址", container.Name, ipFamilyName(service.IPFamily))
 }
 
-// getContainerPort 获取容器端口
-func (w *Watcher) getContainerPort(container *types.ContainerJSON, service *config.ServiceConfig) nat.Port {
-	var targetPort int
+// networkIPs 获取容器在所选网络中的IP地址，根据服务的 ip_family 返回IPv4地址、IPv6地址或两者
+// host网络使用宿主机IP；bridgeUsesHost 为 true 时bridge网络也使用宿主机IP（配合宿主机端口映射）
+func (w *Watcher) networkIPs(container *types.ContainerJSON, service *config.ServiceConfig, networkName string, bridgeUsesHost bool) []string {
+	if networkName == "host" || (networkName == "bridge" && bridgeUsesHost) {
+		return w.hostIPs(service.IPFamily)
+	}
+	return endpointIPs(container.NetworkSettings.Networks[networkName], service.IPFamily)
+}
 
-	if service.Type == "http" {
-		targetPort = service.Port
-	} else if service.Type == "stream" {
-		targetPort = service.ContainerPort
+// checkDNSNetwork 检查是否可以在该网络中通过容器名称访问上游服务器
+// 只有用户自定义网络提供容器名称解析，并且nginx容器必须连接到同一网络
+func (w *Watcher) checkDNSNetwork(networkName string) error {
+	if networkName == "host" || networkName == "bridge" {
+		return fmt.Errorf("网络 %s 不支持通过容器名称访问，dns 地址模式需要用户自定义网络", networkName)
 	}
 
-	// 检查是否是host网络模式
-	if _, exists := container.NetworkSettings.Networks["host"]; exists {
-		// host网络模式，直接返回配置的端口
-		return nat.Port(fmt.Sprintf("%d/tcp", targetPort))
+	nginxContainer := w.config.NginxContainerName()
+	if nginxContainer == "" {
+		// 未配置nginx容器，无法检查
+		return nil
 	}
 
-	// 检查是否是bridge网络模式
-	if _, exists := container.NetworkSettings.Networks["bridge"]; exists {
-		// bridge网络模式，查找宿主机端口映射
-		portStr := fmt.Sprintf("%d/tcp", targetPort)
-		port := nat.Port(portStr)
+	info, err := w.client.ContainerInspect(context.Background(), nginxContainer)
+	if err != nil {
+		return fmt.Errorf("获取nginx容器 %s 信息失败: %w", nginxContainer, err)
+	}
+	if _, exists := info.NetworkSettings.Networks[networkName]; !exists {
+		return fmt.Errorf("nginx容器 %s 未连接到网络 %s（已连接: %v），无法通过容器名称访问上游服务器",
+			nginxContainer, networkName, sortedNetworkNames(&info))
+	}
+	return nil
+}
 
-		if portBindings, exists := container.NetworkSettings.Ports[port]; exists && len(portBindings) > 0 {
-			// 返回宿主机端口
-			hostPort := portBindings[0].HostPort
-			return nat.Port(fmt.Sprintf("%s/tcp", hostPort))
+// hostIPs 按IP协议族获取宿主机IP，用于host网络和bridge端口映射
+func (w *Watcher) hostIPs(ipFamily string) []string {
+	var ips []string
+	switch ipFamily {
+	case config.IPFamilyIPv6:
+		if w.config.Global.HostIPv6 != "" {
+			ips = append(ips, w.config.Global.HostIPv6)
+		}
+	case config.IPFamilyDual:
+		if w.config.Global.HostIP != "" {
+			ips = append(ips, w.config.Global.HostIP)
 		}
+		if w.config.Global.HostIPv6 != "" {
+			ips = append(ips, w.config.Global.HostIPv6)
+		}
+	default:
+		if w.config.Global.HostIP != "" {
+			ips = append(ips, w.config.Global.HostIP)
+		}
+	}
+	return ips
+}
+
+// endpointIPs 按IP协议族获取容器在某个网络中的地址
+func endpointIPs(endpoint *network.EndpointSettings, ipFamily string) []string {
+	if endpoint == nil {
+		return nil
+	}
+
+	var ips []string
+	switch ipFamily {
+	case config.IPFamilyIPv6:
+		if endpoint.GlobalIPv6Address != "" {
+			ips = append(ips, endpoint.GlobalIPv6Address)
+		}
+	case config.IPFamilyDual:
+		if endpoint.IPAddress != "" {
+			ips = append(ips, endpoint.IPAddress)
+		}
+		if endpoint.GlobalIPv6Address != "" {
+			ips = append(ips, endpoint.GlobalIPv6Address)
+		}
+	default:
+		if endpoint.IPAddress != "" {
+			ips = append(ips, endpoint.IPAddress)
+		}
+	}
+	return ips
+}
+
+// ipFamilyName 获取IP协议族名称，用于日志
+func ipFamilyName(ipFamily string) string {
+	if ipFamily == "" {
+		return config.IPFamilyIPv4
+	}
+	return ipFamily
+}
+
+// preferredNetworks 获取服务指定的网络，未指定时使用全局默认网络
+func (w *Watcher) preferredNetworks(service *config.ServiceConfig) []string {
+	if len(service.Network) > 0 {
+		return service.Network
+	}
+	return w.config.Global.DefaultNetwork
+}
+
+// sortedNetworkNames 获取容器连接的网络名称，按名称排序
+func sortedNetworkNames(container *types.ContainerJSON) []string {
+	names := make([]string, 0, len(container.NetworkSettings.Networks))
+	for networkName := range container.NetworkSettings.Networks {
+		names = append(names, networkName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getContainerPort 获取容器端口，usePublished 为 true 时返回发布到宿主机的端口和使用的端口映射
+// HTTP服务只使用TCP端口，Stream服务按 protocol 使用TCP端口、UDP端口或两者
+func (w *Watcher) getContainerPort(container *types.ContainerJSON, service *config.ServiceConfig, usePublished bool) (nat.Port, string, error) {
+	protocols := serviceProtocols(service)
+	targetPort, err := servicePort(container, service, protocols[0])
+	if err != nil {
+		return "", "", err
+	}
+
+	if !usePublished {
+		// 直接访问容器（如host、macvlan网络）时使用容器内部端口
+		return nat.Port(fmt.Sprintf("%d/%s", targetPort, protocols[0])), "", nil
+	}
+
+	// 查找宿主机端口映射，TCP和UDP共用一个upstream，必须映射到同一个宿主机端口
+	var hostPort string
+	var descriptions []string
+	for _, protocol := range protocols {
+		port := nat.Port(fmt.Sprintf("%d/%s", targetPort, protocol))
+		portBindings := container.NetworkSettings.Ports[port]
+		if len(portBindings) == 0 {
+			if service.Type == "http" && len(container.NetworkSettings.Ports[nat.Port(fmt.Sprintf("%d/udp", targetPort))]) > 0 {
+				return "", "", fmt.Errorf("容器端口 %d 只发布了UDP端口，HTTP服务不能使用UDP端口", targetPort)
+			}
+			return "", "", fmt.Errorf("容器端口 %s 未发布到宿主机", port)
+		}
+		binding, err := w.matchPortBinding(portBindings, service.IPFamily)
+		if err != nil {
+			return "", "", fmt.Errorf("容器端口 %s %w", port, err)
+		}
+		if hostPort != "" && binding.HostPort != hostPort {
+			return "", "", fmt.Errorf("容器端口 %d 的TCP和UDP端口映射到了不同的宿主机端口 %s、%s", targetPort, hostPort, binding.HostPort)
+		}
+		hostPort = binding.HostPort
+
+		hostIP := binding.HostIP
+		if hostIP == "" {
+			hostIP = "0.0.0.0"
+		}
+		descriptions = append(descriptions, fmt.Sprintf("%s->%s", net.JoinHostPort(hostIP, binding.HostPort), port))
+	}
+	return nat.Port(fmt.Sprintf("%s/%s", hostPort, protocols[0])), strings.Join(descriptions, ", "), nil
+}
+
+// serviceProtocols 获取服务使用的端口协议
+func serviceProtocols(service *config.ServiceConfig) []string {
+	if service.Type != "stream" {
+		return []string{"tcp"}
+	}
+	switch service.StreamProtocol() {
+	case config.ProtocolUDP:
+		return []string{"udp"}
+	case config.ProtocolBoth:
+		return []string{"tcp", "udp"}
+	default:
+		return []string{"tcp"}
+	}
+}
+
+// servicePort 获取服务的容器内部端口，未配置时根据容器暴露的 protocol 端口自动检测
+func servicePort(container *types.ContainerJSON, service *config.ServiceConfig, protocol string) (int, error) {
+	if service.Type == "http" && service.Port != 0 {
+		return service.Port, nil
+	}
+	if service.Type == "stream" && service.ContainerPort != 0 {
+		return service.ContainerPort, nil
+	}
+	return detectContainerPort(container, protocol)
+}
+
+// detectContainerPort 根据 docker-tool.port 标签或容器暴露的端口检测服务端口
+// 容器只暴露了一个该协议的端口时使用该端口，暴露了多个端口时需要通过标签指定
+func detectContainerPort(container *types.ContainerJSON, protocol string) (int, error) {
+	if container.Config != nil {
+		if value, exists := container.Config.Labels[config.LabelPort]; exists {
+			port, err := strconv.Atoi(value)
+			if err != nil || port <= 0 || port > 65535 {
+				return 0, fmt.Errorf("容器 %s 的标签 %s 的值无效: %s", container.Name, config.LabelPort, value)
+			}
+			return port, nil
+		}
+	}
 
-		// 如果没有找到TCP端口，尝试UDP
-		portStr = fmt.Sprintf("%d/udp", targetPort)
-		port = nat.Port(portStr)
-		if portBindings, exists := container.NetworkSettings.Ports[port]; exists && len(portBindings) > 0 {
-			hostPort := portBindings[0].HostPort
-			return nat.Port(fmt.Sprintf("%s/udp", hostPort))
+	// EXPOSE 声明的端口和发布的端口
+	exposed := make(map[int]struct{})
+	if container.Config != nil {
+		for port := range container.Config.ExposedPorts {
+			if port.Proto() == protocol {
+				exposed[port.Int()] = struct{}{}
+			}
+		}
+	}
+	for port := range container.NetworkSettings.Ports {
+		if port.Proto() == protocol {
+			exposed[port.Int()] = struct{}{}
 		}
 	}
 
-	// 对于其他网络模式（如macvlan），使用容器内部端口
-	portStr := fmt.Sprintf("%d/tcp", targetPort)
-	port := nat.Port(portStr)
+	ports := make([]int, 0, len(exposed))
+	for port := range exposed {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+
+	switch len(ports) {
+	case 0:
+		return 0, fmt.Errorf("容器 %s 没有暴露 %s 端口，请在服务配置中指定端口或设置标签 %s", container.Name, protocol, config.LabelPort)
+	case 1:
+		return ports[0], nil
+	default:
+		return 0, fmt.Errorf("容器 %s 暴露了多个 %s 端口 %v，请在服务配置中指定端口或设置标签 %s", container.Name, protocol, ports, config.LabelPort)
+	}
+}
 
-	// 检查端口是否暴露
-	if _, exists := container.NetworkSettings.Ports[port]; exists {
-		return port
+// matchPortBinding 选择与宿主机IP匹配的端口映射
+// 优先选择绑定到 host_ip（或 host_ipv6）的映射，其次是绑定到所有地址（0.0.0.0、::）的映射
+func (w *Watcher) matchPortBinding(portBindings []nat.PortBinding, ipFamily string) (nat.PortBinding, error) {
+	var wanted []string
+	switch ipFamily {
+	case config.IPFamilyIPv6:
+		wanted = []string{w.config.Global.HostIPv6, "::"}
+	case config.IPFamilyDual:
+		wanted = []string{w.config.Global.HostIP, w.config.Global.HostIPv6, "0.0.0.0", "::"}
+	default:
+		wanted = []string{w.config.Global.HostIP, "0.0.0.0"}
 	}
+	// 未指定宿主机IP的映射同样绑定到所有地址
+	wanted = append(wanted, "")
 
-	// 如果没有找到TCP端口，尝试UDP
-	portStr = fmt.Sprintf("%d/udp", targetPort)
-	port = nat.Port(portStr)
-	if _, exists := container.NetworkSettings.Ports[port]; exists {
-		return port
+	for i, hostIP := range wanted {
+		// 跳过未配置的 host_ip 和 host_ipv6
+		if hostIP == "" && i < len(wanted)-1 {
+			continue
+		}
+		for _, binding := range portBindings {
+			if binding.HostIP == hostIP {
+				return binding, nil
+			}
+		}
 	}
 
-	// 返回默认端口
-	return nat.Port(fmt.Sprintf("%d/tcp", targetPort))
+	bound := make([]string, 0, len(portBindings))
+	for _, binding := range portBindings {
+		bound = append(bound, net.JoinHostPort(binding.HostIP, binding.HostPort))
+	}
+	return nat.PortBinding{}, fmt.Errorf("只发布到 %v，没有与宿主机IP匹配的端口映射", bound)
 }
 
 // watchConfigFile 监听配置文件变化
@@ -400,15 +993,15 @@ func (w *Watcher) watchConfigFile(ctx context.Context) {
 			if w.config.HasChanged() {
 				log.Println("检测到配置文件变化，重新加载配置...")
 
-				// 重新加载配置
-				if err := w.config.Reload(); err != nil {
+				// 重新加载配置，替换配置时不能有事件处理、定期同步和nginx重载在读取配置
+				w.syncMutex.Lock()
+				err := w.nginxMgr.ReloadConfig()
+				w.syncMutex.Unlock()
+				if err != nil {
 					log.Printf("警告: 重新加载配置文件失败，继续使用当前配置: %v", err)
 					continue
 				}
 
-				// 更新nginx管理器配置
-				w.nginxMgr.UpdateConfig(w.config)
-
 				log.Println("成功: 配置文件已重新加载，重新扫描所有容器...")
 
 				// 重新扫描所有现有容器
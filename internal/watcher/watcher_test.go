@@ -0,0 +1,122 @@
+package watcher
+
+import (
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+
+	"docker-tool/internal/config"
+)
+
+func TestMatchPortBinding(t *testing.T) {
+	tests := []struct {
+		name     string
+		hostIP   string
+		hostIPv6 string
+		ipFamily string
+		bindings []nat.PortBinding
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "优先使用host_ip",
+			hostIP:   "192.168.1.10",
+			bindings: []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "8080"}, {HostIP: "192.168.1.10", HostPort: "8081"}},
+			want:     "8081",
+		},
+		{
+			name:     "host_ip在前时同样优先",
+			hostIP:   "192.168.1.10",
+			bindings: []nat.PortBinding{{HostIP: "192.168.1.10", HostPort: "8081"}, {HostIP: "0.0.0.0", HostPort: "8080"}},
+			want:     "8081",
+		},
+		{
+			name:     "只发布到所有地址",
+			hostIP:   "192.168.1.10",
+			bindings: []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "8080"}},
+			want:     "8080",
+		},
+		{
+			name:     "未指定宿主机IP的映射",
+			hostIP:   "192.168.1.10",
+			bindings: []nat.PortBinding{{HostIP: "", HostPort: "8080"}},
+			want:     "8080",
+		},
+		{
+			name:     "未配置host_ip时不优先使用未指定宿主机IP的映射",
+			bindings: []nat.PortBinding{{HostIP: "", HostPort: "8080"}, {HostIP: "0.0.0.0", HostPort: "8081"}},
+			want:     "8081",
+		},
+		{
+			name:     "ipv4不使用IPv6地址的映射",
+			hostIP:   "192.168.1.10",
+			bindings: []nat.PortBinding{{HostIP: "::", HostPort: "8081"}, {HostIP: "0.0.0.0", HostPort: "8080"}},
+			want:     "8080",
+		},
+		{
+			name:     "ipv6优先使用host_ipv6",
+			hostIPv6: "fd00::10",
+			ipFamily: config.IPFamilyIPv6,
+			bindings: []nat.PortBinding{{HostIP: "::", HostPort: "8080"}, {HostIP: "fd00::10", HostPort: "8081"}},
+			want:     "8081",
+		},
+		{
+			name:     "ipv6使用所有IPv6地址的映射",
+			hostIP:   "192.168.1.10",
+			hostIPv6: "fd00::10",
+			ipFamily: config.IPFamilyIPv6,
+			bindings: []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "8080"}, {HostIP: "::", HostPort: "8081"}},
+			want:     "8081",
+		},
+		{
+			name:     "dual优先使用host_ip",
+			hostIP:   "192.168.1.10",
+			hostIPv6: "fd00::10",
+			ipFamily: config.IPFamilyDual,
+			bindings: []nat.PortBinding{{HostIP: "fd00::10", HostPort: "8081"}, {HostIP: "192.168.1.10", HostPort: "8080"}},
+			want:     "8080",
+		},
+		{
+			name:     "dual使用所有IPv4地址优先于所有IPv6地址",
+			ipFamily: config.IPFamilyDual,
+			bindings: []nat.PortBinding{{HostIP: "::", HostPort: "8081"}, {HostIP: "0.0.0.0", HostPort: "8080"}},
+			want:     "8080",
+		},
+		{
+			name:     "没有匹配的映射",
+			hostIP:   "192.168.1.10",
+			bindings: []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: "8080"}},
+			wantErr:  true,
+		},
+		{
+			name:     "ipv6没有匹配的映射",
+			hostIPv6: "fd00::10",
+			ipFamily: config.IPFamilyIPv6,
+			bindings: []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "8080"}},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Global.HostIP = tt.hostIP
+			cfg.Global.HostIPv6 = tt.hostIPv6
+			w := &Watcher{config: cfg}
+
+			got, err := w.matchPortBinding(tt.bindings, tt.ipFamily)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望返回错误，实际匹配到 %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("matchPortBinding() 错误: %v", err)
+			}
+			if got.HostPort != tt.want {
+				t.Errorf("HostPort = %s, 期望 %s", got.HostPort, tt.want)
+			}
+		})
+	}
+}